@@ -3,6 +3,9 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/subash-0044/beaver-vault/pkg/bootstrap"
 	"github.com/subash-0044/beaver-vault/pkg/config"
@@ -13,6 +16,7 @@ func main() {
 	configPath := flag.String("config", "config/config.yaml", "path to config file")
 	nodeID := flag.String("node-id", "", "node ID for this instance")
 	httpPort := flag.Int("http-port", 0, "HTTP port for this instance")
+	grpcPort := flag.Int("grpc-port", 0, "gRPC port for this instance")
 	raftPort := flag.Int("raft-port", 0, "Raft port for this instance")
 	raftHost := flag.String("raft-host", "", "Raft host for this instance")
 	flag.Parse()
@@ -30,6 +34,9 @@ func main() {
 	if *httpPort != 0 {
 		cfg.Server.Port = *httpPort
 	}
+	if *grpcPort != 0 {
+		cfg.Server.GRPCPort = *grpcPort
+	}
 	if *raftPort != 0 {
 		cfg.Raft.Port = *raftPort
 	}
@@ -42,12 +49,37 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize server: %v", err)
 	}
-	defer components.Cleanup()
+
+	// Start gRPC server
+	if components.GRPC != nil {
+		go func() {
+			log.Printf("Starting gRPC server on %s", cfg.Server.GetGRPCAddress())
+			if err := components.GRPC.Run(cfg.Server.GetGRPCAddress()); err != nil {
+				log.Printf("gRPC server failed: %v", err)
+			}
+		}()
+	}
 
 	// Start server
-	log.Printf("Starting server on %s", cfg.Server.GetHTTPAddress())
-	if err := components.Server.Run(cfg.Server.GetHTTPAddress()); err != nil {
-		defer components.Cleanup()
-		log.Fatalf("Server failed: %v", err) //nolint:gocritic
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s", cfg.Server.GetHTTPAddress())
+		errCh <- components.Server.Run(cfg.Server.GetHTTPAddress())
+	}()
+
+	// Deferred calls do not run when the process is killed by a signal, so
+	// stop gracefully on SIGINT and SIGTERM
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-sigCh:
+		log.Printf("Received %s, shutting down", sig)
+		components.Shutdown()
+	case err := <-errCh:
+		components.Shutdown()
+		if err != nil {
+			log.Fatalf("Server failed: %v", err)
+		}
 	}
 }
@@ -1,30 +1,45 @@
 package bootstrap
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/subash-0044/beaver-vault/pkg/config"
 	"github.com/subash-0044/beaver-vault/pkg/consensus"
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/grpcserver"
 	"github.com/subash-0044/beaver-vault/pkg/handler"
+	"github.com/subash-0044/beaver-vault/pkg/metrics"
 	"github.com/subash-0044/beaver-vault/pkg/server"
 	"github.com/subash-0044/beaver-vault/pkg/storage"
+	"github.com/subash-0044/beaver-vault/pkg/tlsutil"
 )
 
 // ServerComponents holds all the components needed to run the server
 type ServerComponents struct {
 	Server    *server.Server
+	GRPC      *grpcserver.Server
 	Consensus *consensus.Raft
 	Transport *raft.NetworkTransport
 	DB        *badger.DB
 	Cleanup   func()
+	// DrainTimeout bounds how long Shutdown waits for in-flight requests
+	DrainTimeout time.Duration
 }
 
+// DefaultDrainTimeout is used when no drain timeout is configured
+const DefaultDrainTimeout = 10 * time.Second
+
 // InitializeServer sets up all the components needed to run the server
 func InitializeServer(cfg *config.Config) (*ServerComponents, error) {
 	// Create data directory if it doesn't exist
@@ -32,16 +47,117 @@ func InitializeServer(cfg *config.Config) (*ServerComponents, error) {
 		return nil, fmt.Errorf("failed to create data directory: %v", err)
 	}
 
+	drainTimeout := DefaultDrainTimeout
+	if cfg.Server.DrainTimeout != "" {
+		var err error
+		if drainTimeout, err = time.ParseDuration(cfg.Server.DrainTimeout); err != nil {
+			return nil, fmt.Errorf("invalid drain timeout: %v", err)
+		}
+	}
+
+	initialCluster, err := consensus.ParseInitialCluster(cfg.Raft.InitialCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	joinRetry, err := parseOptionalDuration(cfg.Join.RetryInterval, consensus.DefaultJoinRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid join retry interval: %v", err)
+	}
+	joinMaxRetry, err := parseOptionalDuration(cfg.Join.MaxRetryInterval, consensus.DefaultJoinMaxRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid join max retry interval: %v", err)
+	}
+
+	readBarrierTimeout, err := parseOptionalDuration(cfg.Raft.ReadBarrierTimeout, handler.DefaultReadBarrierTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid read barrier timeout: %v", err)
+	}
+
+	var groupCommitWindow time.Duration
+	if cfg.Server.GroupCommit.Enabled {
+		var err error
+		if groupCommitWindow, err = time.ParseDuration(cfg.Server.GroupCommit.Window); err != nil {
+			return nil, fmt.Errorf("invalid group commit window: %v", err)
+		}
+	}
+
+	// Values are sealed with data keys wrapped by the master key, and Badger
+	// can encrypt its own files with keys derived from it
+	var keyring *encryption.Keyring
+	var badgerKey, raftKey []byte
+	if cfg.Encryption.Enabled {
+		master, err := encryption.LoadMasterKey(cfg.Encryption.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if keyring, err = encryption.NewKeyring(master); err != nil {
+			return nil, err
+		}
+		if cfg.Encryption.Badger {
+			badgerKey = encryption.DeriveKey(master, "badger")
+			raftKey = encryption.DeriveKey(master, "raft")
+		}
+	}
+
+	// One set of certificates serves the APIs and the Raft transport, and is
+	// reloaded on SIGHUP or when the files change
+	var certs *tlsutil.Reloader
+	if cfg.TLS.Enabled {
+		reloadInterval := tlsutil.DefaultReloadInterval
+		if cfg.TLS.ReloadInterval != "" {
+			var err error
+			if reloadInterval, err = time.ParseDuration(cfg.TLS.ReloadInterval); err != nil {
+				return nil, fmt.Errorf("invalid tls reload interval: %v", err)
+			}
+		}
+		var err error
+		certs, err = tlsutil.NewReloader(tlsutil.Files{
+			CertFile: cfg.TLS.CertFile,
+			KeyFile:  cfg.TLS.KeyFile,
+			CAFile:   cfg.TLS.CAFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		certs.Watch(reloadInterval)
+	}
+
+	// Metrics are collected from the FSM, Raft, Badger and the HTTP server,
+	// and raft's own go-metrics are bridged in
+	m := metrics.New()
+	if err := m.BridgeGoMetrics(); err != nil {
+		return nil, fmt.Errorf("failed to set up metrics: %v", err)
+	}
+
+	// Everything this node stores lives under its own directory, which is
+	// locked so that a second process cannot open it
+	nodeDir, err := storage.OpenNodeDir(filepath.Join(cfg.Data.Directory, cfg.Raft.NodeID), cfg.Raft.NodeID)
+	if err != nil {
+		if certs != nil {
+			certs.Close()
+		}
+		return nil, err
+	}
+
 	// Initialize BadgerDB
-	badgerDir := filepath.Join(cfg.Data.Directory, cfg.Raft.NodeID, "badger")
+	badgerDir := filepath.Join(nodeDir.Path, "badger")
 	badgerStore, err := storage.NewBadgerStore(storage.Options{
 		Dir:             badgerDir,
 		CreateIfMissing: true,
+		EncryptionKey:   badgerKey,
 	})
 	if err != nil {
+		_ = nodeDir.Close()
+		if certs != nil {
+			certs.Close()
+		}
 		return nil, fmt.Errorf("failed to create BadgerStore: %v", err)
 	}
 
+	// The watcher carries committed changes from the FSM to watch clients
+	watcher := fsm.NewWatcher(fsm.DefaultWatchHistory)
+
 	// Use consensus package to create Raft node
 	raftNode, transport, err := consensus.NewRaftNode(consensus.RaftNodeOptions{
 		NodeID:           cfg.Raft.NodeID,
@@ -54,32 +170,197 @@ func InitializeServer(cfg *config.Config) (*ServerComponents, error) {
 		CommitTimeout:    cfg.Raft.CommitTimeout,
 		DB:               badgerStore.DB,
 		Bootstrap:        cfg.Raft.Bootstrap,
+		InitialCluster:   initialCluster,
+		MinVoters:        cfg.Raft.MinVoters,
+		Watcher:          watcher,
+		Keyring:          keyring,
+		EncryptionKey:    raftKey,
+		TLS:              certs,
+		OnApply:          m.ObserveApply,
 	})
 	if err != nil {
+		_ = badgerStore.Close()
+		_ = nodeDir.Close()
+		if certs != nil {
+			certs.Close()
+		}
 		return nil, fmt.Errorf("failed to initialize Raft node: %v", err)
 	}
 
+	m.RegisterRaft(raftNode.GetRaft())
+	m.RegisterBadger("data", badgerStore.DB)
+	if db := raftNode.LogStoreDB(); db != nil {
+		m.RegisterBadger("raft", db)
+	}
+
 	// Create handler and server
-	h := handler.NewActionHandler(raftNode.GetRaft(), badgerStore.DB)
-	s := server.NewGinServer(h, raftNode)
+	h := handler.NewActionHandler(raftNode.GetRaft(), badgerStore.DB).
+		WithWatcher(watcher).
+		WithSecretVersions(cfg.Secrets.MaxVersions).
+		WithReadBarrierTimeout(readBarrierTimeout)
+	if keyring != nil {
+		h.WithKeyring(keyring)
+	}
+	if cfg.Auth.Enabled {
+		h.WithAuth()
+	}
+	if gc := cfg.Server.GroupCommit; gc.Enabled {
+		h.WithGroupCommit(handler.GroupCommitOptions{
+			Window:     groupCommitWindow,
+			MaxBatch:   gc.MaxBatch,
+			QueueDepth: gc.QueueDepth,
+		})
+	}
+	s := server.NewGinServer(h, raftNode, server.Options{
+		ForwardMode: cfg.Server.Forward,
+		TLS:         certs,
+		ClientAuth:  cfg.TLS.ClientAuth,
+		Metrics:     m,
+	})
+
+	// The gRPC API is optional and shares the handler with the HTTP API
+	var g *grpcserver.Server
+	if cfg.Server.GRPCPort != 0 {
+		var opts []grpc.ServerOption
+		if certs != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(certs.ServerConfig(cfg.TLS.ClientAuth))))
+		}
+		g = grpcserver.NewGRPCServer(h, raftNode, opts...)
+	}
+
+	// Publish our HTTP address whenever we lead, so followers can forward writes
+	raftNode.AdvertiseHTTP(cfg.Server.GetHTTPAddress())
+
+	// A new node asks the seeds to add it to their cluster
+	joinCtx, cancelJoin := context.WithCancel(context.Background())
+	if len(cfg.Join.Seeds) > 0 && !raftNode.HasExistingState() {
+		req := consensus.RequestJoin{
+			NodeID:      cfg.Raft.NodeID,
+			RaftAddress: string(transport.LocalAddr()),
+			HTTPAddress: cfg.Server.GetHTTPAddress(),
+			Suffrage:    cfg.Join.Suffrage,
+		}
+		go func() {
+			if err := raftNode.AutoJoin(joinCtx, req, consensus.JoinOptions{
+				Seeds:            cfg.Join.Seeds,
+				Token:            cfg.Join.Token,
+				TLS:              certs,
+				RetryInterval:    joinRetry,
+				MaxRetryInterval: joinMaxRetry,
+			}); err != nil && joinCtx.Err() == nil {
+				log.Printf("Auto-join stopped: %v", err)
+			}
+		}()
+	}
+
+	// The node that bootstraps the cluster creates its root token; with an
+	// initial cluster, whichever member is elected does
+	if cfg.Auth.Enabled && (cfg.Raft.Bootstrap || len(initialCluster) > 0) {
+		go initRootToken(h, cfg.Auth.RootTokenFile)
+	}
 
 	cleanup := func() {
+		cancelJoin()
+		if g != nil {
+			g.Stop()
+		}
+		h.Close()
+		m.Close()
 		if err := raftNode.GetRaft().Shutdown().Error(); err != nil {
 			log.Printf("Error shutting down Raft: %v", err)
 		}
 		if err := transport.Close(); err != nil {
 			log.Printf("Error closing transport: %v", err)
 		}
+		if err := raftNode.Close(); err != nil {
+			log.Printf("Error closing Raft log store: %v", err)
+		}
 		if err := badgerStore.Close(); err != nil {
 			log.Printf("Error closing BadgerDB: %v", err)
 		}
+		if err := nodeDir.Close(); err != nil {
+			log.Printf("Error unlocking data directory: %v", err)
+		}
+		if certs != nil {
+			certs.Close()
+		}
 	}
 
 	return &ServerComponents{
 		Server:    s,
+		GRPC:      g,
 		Consensus: raftNode,
 		Transport: transport,
 		DB:        badgerStore.DB,
 		Cleanup:   cleanup,
+
+		DrainTimeout: drainTimeout,
 	}, nil
 }
+
+// Shutdown stops the node gracefully: the APIs stop accepting requests and
+// drain the in-flight ones within DrainTimeout, leadership moves to another
+// voter if this node leads, and then Raft, the transport and the stores are
+// closed by Cleanup.
+func (c *ServerComponents) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.DrainTimeout)
+	defer cancel()
+
+	if err := c.Server.Shutdown(ctx); err != nil {
+		log.Printf("Error draining HTTP requests: %v", err)
+	}
+	if c.GRPC != nil {
+		if err := c.GRPC.Shutdown(ctx); err != nil {
+			log.Printf("Error draining gRPC calls: %v", err)
+		}
+	}
+	if err := c.Consensus.TransferLeadership(); err != nil {
+		log.Printf("Error transferring leadership: %v", err)
+	}
+	c.Cleanup()
+}
+
+// parseOptionalDuration parses value, returning fallback when it is empty.
+func parseOptionalDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// rootTokenTimeout bounds the wait for leadership before the root token is
+// created.
+const rootTokenTimeout = time.Minute
+
+// initRootToken waits until this node leads the cluster, then creates the
+// root token unless the cluster already has one. The token is written to
+// tokenFile when it is set, and logged otherwise.
+func initRootToken(h *handler.Handler, tokenFile string) {
+	deadline := time.Now().Add(rootTokenTimeout)
+	for !h.IsLeader() {
+		if time.Now().After(deadline) {
+			log.Printf("Root token not created: this node did not become leader")
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	secret, created, err := h.InitRootToken()
+	if err != nil {
+		log.Printf("Error creating root token: %v", err)
+		return
+	}
+	if !created {
+		return
+	}
+	if tokenFile == "" {
+		log.Printf("WARNING: auth.rootTokenFile is not set, so the root token is logged; anyone who can read this log can use it")
+		log.Printf("Created root token: %s", secret)
+		return
+	}
+	if err := os.WriteFile(tokenFile, []byte(secret+"\n"), 0600); err != nil {
+		log.Printf("Error writing root token to %s: %v", tokenFile, err)
+		return
+	}
+	log.Printf("Created root token, written to %s", tokenFile)
+}
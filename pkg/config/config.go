@@ -12,12 +12,92 @@ type Config struct {
 	Server ServerConfig `yaml:"server"`
 	Raft   RaftConfig   `yaml:"raft"`
 	Data   DataConfig   `yaml:"data"`
+	// Encryption configures encryption at rest
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// Secrets configures the versioned secret engine
+	Secrets SecretsConfig `yaml:"secrets"`
+	// Auth configures token authentication
+	Auth AuthConfig `yaml:"auth"`
+	// TLS configures TLS for the APIs and mutual TLS between Raft peers
+	TLS TLSConfig `yaml:"tls"`
+	// Join configures joining an existing cluster on first start
+	Join JoinConfig `yaml:"join"`
+}
+
+// JoinConfig holds auto-join configuration
+type JoinConfig struct {
+	// Seeds are HTTP addresses of cluster members
+	Seeds []string `yaml:"seeds"`
+	// Token authorizes the join when auth is enabled
+	Token string `yaml:"token"`
+	// Suffrage is "voter" (default) or "nonvoter" for read replicas
+	Suffrage string `yaml:"suffrage"`
+	// RetryInterval is the first wait between attempts; it doubles up to
+	// MaxRetryInterval
+	RetryInterval    string `yaml:"retryInterval"`
+	MaxRetryInterval string `yaml:"maxRetryInterval"`
+}
+
+// TLSConfig holds TLS configuration
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// CAFile signs the certificates of every node and, with ClientAuth, of
+	// API clients
+	CAFile string `yaml:"caFile"`
+	// ClientAuth requires HTTP and gRPC clients to present a certificate
+	ClientAuth bool `yaml:"clientAuth"`
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval string `yaml:"reloadInterval"`
+}
+
+// AuthConfig holds token authentication configuration
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// RootTokenFile receives the root token created when the cluster is
+	// bootstrapped; if empty the token is only logged
+	RootTokenFile string `yaml:"rootTokenFile"`
+}
+
+// SecretsConfig holds secret engine configuration
+type SecretsConfig struct {
+	// MaxVersions is the number of versions kept per secret; 0 keeps 10
+	MaxVersions int `yaml:"maxVersions"`
+}
+
+// EncryptionConfig holds encryption at rest configuration
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// KeyFile holds the master key; if empty it is read from the
+	// BEAVER_MASTER_KEY environment variable
+	KeyFile string `yaml:"keyFile"`
+	// Badger also encrypts the Badger files with a key derived from the
+	// master key. It can only be enabled on a new data directory.
+	Badger bool `yaml:"badger"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
+	// Forward is how followers handle writes: "proxy" or "redirect"
+	Forward string `yaml:"forward"`
+	// GRPCPort serves the gRPC API on Host when non-zero
+	GRPCPort int `yaml:"grpcPort"`
+	// GroupCommit batches concurrent writes into shared log entries
+	GroupCommit GroupCommitConfig `yaml:"groupCommit"`
+	// DrainTimeout bounds how long a stopping node waits for in-flight
+	// requests; defaults to 10s
+	DrainTimeout string `yaml:"drainTimeout"`
+}
+
+// GroupCommitConfig holds write batching configuration
+type GroupCommitConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Window     string `yaml:"window"`
+	MaxBatch   int    `yaml:"maxBatch"`
+	QueueDepth int    `yaml:"queueDepth"`
 }
 
 // RaftConfig holds Raft consensus configuration
@@ -30,6 +110,14 @@ type RaftConfig struct {
 	ElectionTimeout  string `yaml:"electionTimeout"`
 	CommitTimeout    string `yaml:"commitTimeout"`
 	MaxSnapshots     int    `yaml:"maxSnapshots"`
+	// InitialCluster lists the id=address pairs of the voters a new
+	// cluster starts with; every node listed bootstraps with it
+	InitialCluster []string `yaml:"initialCluster"`
+	// MinVoters is the number of voters a drop keeps unless forced
+	MinVoters int `yaml:"minVoters"`
+	// ReadBarrierTimeout bounds how long a linearizable read waits for the
+	// leader to apply what it has committed
+	ReadBarrierTimeout string `yaml:"readBarrierTimeout"`
 }
 
 // DataConfig holds data storage configuration
@@ -57,6 +145,11 @@ func (c *ServerConfig) GetHTTPAddress() string {
 	return fmt.Sprintf("%s:%d", c.Host, c.Port)
 }
 
+// GetGRPCAddress returns the formatted gRPC address
+func (c *ServerConfig) GetGRPCAddress() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.GRPCPort)
+}
+
 // GetRaftAddress returns the formatted Raft address
 func (c *RaftConfig) GetRaftAddress() string {
 	return fmt.Sprintf("%s:%d", c.Host, c.Port)
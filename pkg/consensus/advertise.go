@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// AdvertiseHTTP publishes httpAddr as this node's HTTP API address every time
+// the node becomes leader. Followers read it back to forward writes.
+// Nodes that join later publish their address through RequestJoin instead.
+func (r *Raft) AdvertiseHTTP(httpAddr string) {
+	observations := make(chan raft.Observation, 1)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	})
+	r.GetRaft().RegisterObserver(observer)
+
+	stopCh := r.stopCh
+	go func() {
+		defer r.GetRaft().DeregisterObserver(observer)
+
+		// Leadership may have been acquired before the observer was registered
+		if r.GetRaft().State() == raft.Leader {
+			r.publishHTTPAddress(httpAddr)
+		}
+
+		for {
+			select {
+			case o := <-observations:
+				leader := o.Data.(raft.LeaderObservation)
+				if leader.LeaderID != "" && r.GetRaft().State() == raft.Leader {
+					r.publishHTTPAddress(httpAddr)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// publishHTTPAddress records this leader's HTTP address, logging failures
+// since there is no caller to return them to.
+func (r *Raft) publishHTTPAddress(httpAddr string) {
+	_, leaderID := r.GetRaft().LeaderWithID()
+	if leaderID == "" {
+		return
+	}
+	if err := r.setHTTPAddress(string(leaderID), httpAddr); err != nil {
+		log.Printf("Error publishing HTTP address: %v", err)
+	}
+}
+
+// setHTTPAddress replicates the HTTP address of nodeID.
+func (r *Raft) setHTTPAddress(nodeID, httpAddr string) error {
+	return r.applyCommand(fsm.CommandPayload{
+		Operation: "SET",
+		Key:       fsm.NodeHTTPAddressKey(nodeID),
+		Value:     httpAddr,
+	})
+}
+
+// deleteHTTPAddress forgets the HTTP address of nodeID.
+func (r *Raft) deleteHTTPAddress(nodeID string) error {
+	return r.applyCommand(fsm.CommandPayload{
+		Operation: "DELETE",
+		Key:       fsm.NodeHTTPAddressKey(nodeID),
+	})
+}
+
+// applyCommand replicates a cluster-internal command through the Raft log.
+func (r *Raft) applyCommand(payload fsm.CommandPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error preparing %s payload: %w", payload.Key, err)
+	}
+
+	future := r.GetRaft().Apply(data, 5*time.Second)
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("error applying %s: %w", payload.Key, err)
+	}
+	return nil
+}
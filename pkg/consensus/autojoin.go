@@ -0,0 +1,162 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/subash-0044/beaver-vault/pkg/tlsutil"
+)
+
+// Defaults for JoinOptions
+const (
+	DefaultJoinRetryInterval    = time.Second
+	DefaultJoinMaxRetryInterval = 30 * time.Second
+)
+
+// maxJoinRedirects bounds the redirects followed from a seed to the leader
+const maxJoinRedirects = 5
+
+// JoinOptions configures AutoJoin.
+type JoinOptions struct {
+	// Seeds are the HTTP addresses of cluster members, as host:port or URLs
+	Seeds []string
+	// Token is sent as a bearer token when auth is enabled
+	Token string
+	// TLS, if set, is used to reach the seeds over HTTPS
+	TLS *tlsutil.Reloader
+	// RetryInterval is the wait after the first failed round over the seeds;
+	// it doubles after every round up to MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+}
+
+// AutoJoin asks the seeds to add this node to their cluster until one of
+// them succeeds, the node hears from a leader, or ctx is done. Seeds that
+// are followers proxy the request to the leader or redirect to it.
+func (r *Raft) AutoJoin(ctx context.Context, req RequestJoin, opts JoinOptions) error {
+	if len(opts.Seeds) == 0 {
+		return fmt.Errorf("no join seeds")
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	wait := opts.RetryInterval
+	if wait <= 0 {
+		wait = DefaultJoinRetryInterval
+	}
+	maxWait := opts.MaxRetryInterval
+	if maxWait <= 0 {
+		maxWait = DefaultJoinMaxRetryInterval
+	}
+
+	for {
+		// Added to the cluster some other way, e.g. by an operator
+		if leader, _ := r.raft.LeaderWithID(); leader != "" {
+			return nil
+		}
+		for _, seed := range opts.Seeds {
+			err := joinSeed(ctx, seed, body, opts)
+			if err == nil {
+				log.Printf("Joined the cluster through %s", seed)
+				return nil
+			}
+			log.Printf("Error joining the cluster through %s: %v", seed, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxWait {
+			wait = maxWait
+		}
+	}
+}
+
+// joinSeed posts a join request to seed, following redirects to the leader.
+func joinSeed(ctx context.Context, seed string, body []byte, opts JoinOptions) error {
+	target, err := seedURL(seed, opts.TLS != nil)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i <= maxJoinRedirects; i++ {
+		resp, err := postJoin(ctx, target, body, opts)
+		if err != nil {
+			return err
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			location, err := resp.Location()
+			if err != nil {
+				return fmt.Errorf("invalid redirect: %v", err)
+			}
+			target = location
+		default:
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+	}
+	return fmt.Errorf("too many redirects")
+}
+
+// postJoin sends one join request to target without following redirects,
+// which would drop the token when the leader is on another host.
+func postJoin(ctx context.Context, target *url.URL, body []byte, opts JoinOptions) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if opts.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	if opts.TLS != nil {
+		// Built per request so that reloaded certificates apply
+		host, _, _ := net.SplitHostPort(target.Host)
+		transport.TLSClientConfig = opts.TLS.ClientConfig(host)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return client.Do(httpReq)
+}
+
+// seedURL returns the join endpoint of seed.
+func seedURL(seed string, secure bool) (*url.URL, error) {
+	if !strings.Contains(seed, "://") {
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		seed = scheme + "://" + seed
+	}
+	u, err := url.Parse(seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid join seed %q: %v", seed, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/raft/join"
+	return u, nil
+}
@@ -0,0 +1,75 @@
+package consensus
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// ParseInitialCluster parses the id=address pairs of an initial cluster.
+func ParseInitialCluster(members []string) ([]raft.Server, error) {
+	servers := make([]raft.Server, 0, len(members))
+	ids := make(map[raft.ServerID]bool)
+	addrs := make(map[raft.ServerAddress]bool)
+	for _, member := range members {
+		id, addr, ok := strings.Cut(member, "=")
+		id, addr = strings.TrimSpace(id), strings.TrimSpace(addr)
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid initial cluster member %q, expected id=address", member)
+		}
+		server := raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(id), Address: raft.ServerAddress(addr)}
+		if ids[server.ID] {
+			return nil, fmt.Errorf("duplicate node id %q in initial cluster", id)
+		}
+		if addrs[server.Address] {
+			return nil, fmt.Errorf("duplicate address %q in initial cluster", addr)
+		}
+		ids[server.ID] = true
+		addrs[server.Address] = true
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// bootstrapConfiguration returns the configuration a node bootstraps with:
+// the initial cluster when one is given, which must include the node, or
+// the node alone.
+func bootstrapConfiguration(localID raft.ServerID, localAddr raft.ServerAddress, initialCluster []raft.Server) (raft.Configuration, error) {
+	if len(initialCluster) == 0 {
+		return raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter, ID: localID, Address: localAddr}}}, nil
+	}
+	for _, server := range initialCluster {
+		if server.ID == localID {
+			return raft.Configuration{Servers: initialCluster}, nil
+		}
+	}
+	return raft.Configuration{}, fmt.Errorf("node %q is not in the initial cluster", localID)
+}
+
+// bootstrap forms a new cluster with configuration, unless the node already
+// has Raft state. A node with state only restarts with it; if that state
+// comes from a cluster it is no longer a member of, bootstrapping would not
+// help, so it fails instead of silently waiting to be joined.
+func bootstrap(r *raft.Raft, localID raft.ServerID, configuration raft.Configuration, hasState bool) error {
+	if !hasState {
+		if err := r.BootstrapCluster(configuration).Error(); err != nil {
+			return fmt.Errorf("failed to bootstrap cluster: %v", err)
+		}
+		return nil
+	}
+
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("failed to read existing raft configuration: %v", err)
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.ID == localID {
+			log.Printf("Skipping bootstrap: node %s has existing Raft state", localID)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot bootstrap: node %q has existing Raft state from a cluster it is not a member of; "+
+		"remove its data directory to bootstrap a new cluster, or disable bootstrap and join it to the cluster", localID)
+}
@@ -1,9 +1,23 @@
 package consensus
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,6 +25,7 @@ import (
 	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
 	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/tlsutil"
 )
 
 // setupTestRaft creates a new Raft node for testing
@@ -173,13 +188,26 @@ func TestRaftWithRealNodes(t *testing.T) {
 	assert.NoError(t, response.Error)
 	assert.Equal(t, make(map[string]interface{}), response.Data)
 
-	// Test dropping the follower
+	// Dropping the follower would leave fewer than the minimum voters
 	dropReq := RequestDrop{
 		NodeID: "node2",
 	}
-	success, err = leader.DropRaftHandler(dropReq)
+	_, err = leader.DropRaftHandler(dropReq)
+	assert.ErrorContains(t, err, "below the minimum of 3")
+
+	// Unknown nodes are refused
+	_, err = leader.DropRaftHandler(RequestDrop{NodeID: "node9", Force: true})
+	assert.ErrorContains(t, err, "node node9 is not a member of the cluster")
+
+	// Test dropping the follower
+	dropReq.Force = true
+	result, err := leader.DropRaftHandler(dropReq)
 	assert.NoError(t, err)
-	assert.True(t, success)
+	assert.Equal(t, &DropResult{NodeID: "node2", Suffrage: SuffrageVoter, Removed: true, Forced: true, Voters: 1}, result)
+
+	// The last voter can never be dropped
+	_, err = leader.DropRaftHandler(RequestDrop{NodeID: "node1", Force: true})
+	assert.ErrorContains(t, err, "it is the last voter")
 
 	// Verify the node was dropped
 	timeout = time.Now().Add(3 * time.Second)
@@ -240,16 +268,15 @@ func TestRaftLeaderDrop(t *testing.T) {
 	cfg := leader.GetRaft().GetConfiguration()
 	assert.Equal(t, 3, len(cfg.Configuration().Servers), "Cluster should have three nodes")
 
-	// Drop the leader node
+	// Drop the leader node: it hands leadership over first
 	dropReq := RequestDrop{
 		NodeID: "node1",
+		Force:  true,
 	}
-	success, err = leader.DropRaftHandler(dropReq)
+	result, err := leader.DropRaftHandler(dropReq)
 	assert.NoError(t, err)
-	assert.True(t, success)
-
-	// Wait for new leader election and node removal
-	time.Sleep(2 * time.Second)
+	assert.False(t, result.Removed)
+	assert.Contains(t, []string{"node2", "node3"}, result.TransferredTo)
 
 	// Verify that one of the followers became leader
 	assert.True(t, follower1.GetRaft().State() == raft.Leader || follower2.GetRaft().State() == raft.Leader,
@@ -262,6 +289,16 @@ func TestRaftLeaderDrop(t *testing.T) {
 	} else {
 		newLeader = follower2
 	}
+	assert.Equal(t, result.TransferredTo, string(newLeader.localServerID()))
+
+	// The new leader completes the drop
+	result, err = newLeader.DropRaftHandler(dropReq)
+	assert.NoError(t, err)
+	assert.True(t, result.Removed)
+	assert.Equal(t, 2, result.Voters)
+
+	// Wait for node removal
+	time.Sleep(1 * time.Second)
 
 	// Verify cluster size is now 2
 	cfg = newLeader.GetRaft().GetConfiguration()
@@ -273,3 +310,607 @@ func TestRaftLeaderDrop(t *testing.T) {
 		assert.NotEqual(t, raft.ServerID("node1"), server.ID, "Old leader should not be in the configuration")
 	}
 }
+
+func TestTransferLeadership(t *testing.T) {
+	leader, leaderDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(leaderDir) }()
+
+	assert.Eventually(t, func() bool {
+		return leader.GetRaft().State() == raft.Leader
+	}, 3*time.Second, 100*time.Millisecond, "Node1 should become leader")
+
+	// A single voter has nobody to hand over to
+	assert.NoError(t, leader.TransferLeadership())
+	assert.Equal(t, raft.Leader, leader.GetRaft().State())
+
+	follower, followerDir, followerAddr := setupTestRaft(t, "node2")
+	defer func() { _ = os.RemoveAll(followerDir) }()
+	success, err := leader.JoinRaftHandler(RequestJoin{NodeID: "node2", RaftAddress: string(followerAddr)})
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	// Followers leave leadership alone
+	assert.Eventually(t, func() bool {
+		return follower.GetRaft().AppliedIndex() >= leader.GetRaft().AppliedIndex()
+	}, 3*time.Second, 100*time.Millisecond)
+	assert.NoError(t, follower.TransferLeadership())
+
+	assert.NoError(t, leader.TransferLeadership())
+	assert.Eventually(t, func() bool {
+		return follower.GetRaft().State() == raft.Leader
+	}, 3*time.Second, 100*time.Millisecond, "Node2 should take over leadership")
+}
+
+// startDurableNode starts a single-node cluster through NewRaftNode, backed
+// by the on-disk log store under dataDir.
+func startDurableNode(t *testing.T, dataDir string, port int) (*Raft, *raft.NetworkTransport, *badger.DB) {
+	badgerOpts := badger.DefaultOptions(filepath.Join(dataDir, "node1", "badger"))
+	badgerOpts.Logger = nil
+	db, err := badger.Open(badgerOpts)
+	assert.NoError(t, err)
+
+	node, transport, err := NewRaftNode(RaftNodeOptions{
+		NodeID:           "node1",
+		Host:             "localhost",
+		Port:             port,
+		DataDir:          dataDir,
+		MaxSnapshots:     1,
+		HeartbeatTimeout: "500ms",
+		ElectionTimeout:  "500ms",
+		CommitTimeout:    "5ms",
+		DB:               db,
+		Bootstrap:        true,
+	})
+	assert.NoError(t, err)
+
+	timeout := time.Now().Add(5 * time.Second)
+	for time.Now().Before(timeout) {
+		if node.GetRaft().State() == raft.Leader {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, raft.Leader, node.GetRaft().State(), "node1 should become leader")
+
+	return node, transport, db
+}
+
+// stopDurableNode tears a node down without removing its data directory.
+func stopDurableNode(t *testing.T, node *Raft, transport *raft.NetworkTransport, db *badger.DB) {
+	assert.NoError(t, node.GetRaft().Shutdown().Error())
+	assert.NoError(t, transport.Close())
+	assert.NoError(t, node.Close())
+	assert.NoError(t, db.Close())
+}
+
+func TestRaftRestartKeepsState(t *testing.T) {
+	dataDir := t.TempDir()
+
+	node, transport, db := startDurableNode(t, dataDir, 0)
+	_, port, err := net.SplitHostPort(string(transport.LocalAddr()))
+	assert.NoError(t, err)
+
+	cmd, err := json.Marshal(fsm.CommandPayload{
+		Operation: "SET",
+		Key:       "durable-key",
+		Value:     "durable-value",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, node.GetRaft().Apply(cmd, 5*time.Second).Error())
+
+	// Snapshots are kept with the rest of the node's Raft state
+	assert.NoError(t, node.GetRaft().Snapshot().Error())
+	snapshots, err := os.ReadDir(filepath.Join(dataDir, "node1", "snapshots"))
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	assert.NoDirExists(t, filepath.Join(dataDir, "raft"))
+
+	termBefore := node.GetRaft().Stats()["term"]
+	lastIndexBefore := node.GetRaft().LastIndex()
+	stopDurableNode(t, node, transport, db)
+
+	// Restart on the same address with the same data directory
+	raftPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+	node, transport, db = startDurableNode(t, dataDir, raftPort)
+	defer stopDurableNode(t, node, transport, db)
+
+	termAfter, err := strconv.ParseUint(node.GetRaft().Stats()["term"], 10, 64)
+	assert.NoError(t, err)
+	termBeforeValue, err := strconv.ParseUint(termBefore, 10, 64)
+	assert.NoError(t, err)
+	assert.Greater(t, termAfter, termBeforeValue, "term should continue from the persisted value")
+	assert.Greater(t, node.GetRaft().LastIndex(), lastIndexBefore, "log should be retained across restart")
+
+	// The write committed before the restart is still visible through the FSM
+	cmd, err = json.Marshal(fsm.CommandPayload{
+		Operation: "GET",
+		Key:       "durable-key",
+	})
+	assert.NoError(t, err)
+	future := node.GetRaft().Apply(cmd, 5*time.Second)
+	assert.NoError(t, future.Error())
+	response := future.Response().(*fsm.ApplyResponse)
+	assert.NoError(t, response.Error)
+	assert.Equal(t, "durable-value", response.Data)
+}
+
+func TestRaftRestartAppliesOnce(t *testing.T) {
+	dataDir := t.TempDir()
+
+	node, transport, db := startDurableNode(t, dataDir, 0)
+	_, port, err := net.SplitHostPort(string(transport.LocalAddr()))
+	assert.NoError(t, err)
+
+	apply := func(payload fsm.CommandPayload) *fsm.ApplyResponse {
+		cmd, err := json.Marshal(payload)
+		assert.NoError(t, err)
+		future := node.GetRaft().Apply(cmd, 5*time.Second)
+		assert.NoError(t, future.Error())
+		return future.Response().(*fsm.ApplyResponse)
+	}
+	assert.NoError(t, apply(fsm.CommandPayload{Operation: "SECRET_PUT", Key: "db-creds", Value: "v1"}).Error)
+	assert.NoError(t, apply(fsm.CommandPayload{Operation: "SECRET_PUT", Key: "db-creds", Value: "v2"}).Error)
+	deleted := apply(fsm.CommandPayload{Operation: "SECRET_DELETE", Key: "db-creds", Secret: &fsm.SecretOp{Versions: []uint64{2}}})
+	assert.NoError(t, deleted.Error)
+	assert.NoError(t, apply(fsm.CommandPayload{Operation: "SECRET_DESTROY", Key: "db-creds", Secret: &fsm.SecretOp{Versions: []uint64{1}}}).Error)
+	created := apply(fsm.CommandPayload{Operation: "SET", Key: "once", Value: "v", Mode: fsm.WriteModeCreate})
+	assert.NoError(t, created.Error)
+	stopDurableNode(t, node, transport, db)
+
+	// Without a snapshot the whole log is replayed onto the existing FSM
+	raftPort, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+	node, transport, db = startDurableNode(t, dataDir, raftPort)
+	defer stopDurableNode(t, node, transport, db)
+	assert.NoError(t, node.GetRaft().Barrier(5*time.Second).Error())
+
+	applied, err := fsm.AppliedIndex(db)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, applied, created.Index)
+
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		secret, err := fsm.ReadSecretMetadata(txn, "db-creds")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), secret.CurrentVersion)
+		assert.Len(t, secret.Versions, 2)
+		assert.Equal(t, deleted.Index, secret.Versions[2].DeleteIndex)
+		assert.True(t, secret.Versions[1].Destroyed)
+
+		meta, err := fsm.ReadMeta(txn, "once")
+		assert.NoError(t, err)
+		assert.Equal(t, created.Index, meta.CreateIndex)
+		assert.Equal(t, created.Index, meta.ModIndex)
+		return nil
+	}))
+}
+
+func TestRaftNodeRefusesDataWithoutState(t *testing.T) {
+	dataDir := t.TempDir()
+
+	node, transport, db := startDurableNode(t, dataDir, 0)
+	cmd, err := json.Marshal(fsm.CommandPayload{Operation: "SET", Key: "k", Value: "v"})
+	assert.NoError(t, err)
+	assert.NoError(t, node.GetRaft().Apply(cmd, 5*time.Second).Error())
+	stopDurableNode(t, node, transport, db)
+
+	// The Raft state is lost but the data survives
+	assert.NoError(t, os.RemoveAll(filepath.Join(dataDir, "node1", "raft")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(dataDir, "node1", "snapshots")))
+
+	badgerOpts := badger.DefaultOptions(filepath.Join(dataDir, "node1", "badger"))
+	badgerOpts.Logger = nil
+	db, err = badger.Open(badgerOpts)
+	assert.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	_, _, err = NewRaftNode(RaftNodeOptions{
+		NodeID:           "node1",
+		Host:             "localhost",
+		DataDir:          dataDir,
+		MaxSnapshots:     1,
+		HeartbeatTimeout: "500ms",
+		ElectionTimeout:  "500ms",
+		CommitTimeout:    "5ms",
+		DB:               db,
+		Bootstrap:        true,
+	})
+	assert.ErrorContains(t, err, "there is no Raft state")
+}
+
+// writeTestCertificates writes a CA and a 127.0.0.1 certificate signed by it
+// to dir.
+func writeTestCertificates(t *testing.T, dir string) tlsutil.Files {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	files := tlsutil.Files{
+		CertFile: filepath.Join(dir, "node.crt"),
+		KeyFile:  filepath.Join(dir, "node.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	for name, block := range map[string]*pem.Block{
+		files.CAFile:   {Type: "CERTIFICATE", Bytes: caDER},
+		files.CertFile: {Type: "CERTIFICATE", Bytes: der},
+		files.KeyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		assert.NoError(t, os.WriteFile(name, pem.EncodeToMemory(block), 0600))
+	}
+	return files
+}
+
+func TestTLSTransport(t *testing.T) {
+	files := writeTestCertificates(t, t.TempDir())
+	certs, err := tlsutil.NewReloader(files)
+	assert.NoError(t, err)
+
+	withoutCA, err := tlsutil.NewReloader(tlsutil.Files{CertFile: files.CertFile, KeyFile: files.KeyFile})
+	assert.NoError(t, err)
+	_, err = newTLSStreamLayer("127.0.0.1:0", withoutCA)
+	assert.Error(t, err, "mutual TLS needs a CA")
+
+	server, err := newTransport("127.0.0.1:0", certs)
+	assert.NoError(t, err)
+	defer func() { _ = server.Close() }()
+	client, err := newTransport("127.0.0.1:0", certs)
+	assert.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	go func() {
+		rpc := <-server.Consumer()
+		rpc.Respond(&raft.AppendEntriesResponse{Term: 7, Success: true}, nil)
+	}()
+	var resp raft.AppendEntriesResponse
+	err = client.AppendEntries("server", server.LocalAddr(), &raft.AppendEntriesRequest{Term: 7}, &resp)
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+
+	// Peers with a certificate from another CA are refused
+	rogueCerts, err := tlsutil.NewReloader(writeTestCertificates(t, t.TempDir()))
+	assert.NoError(t, err)
+	rogue, err := newTransport("127.0.0.1:0", rogueCerts)
+	assert.NoError(t, err)
+	defer func() { _ = rogue.Close() }()
+	err = rogue.AppendEntries("server", server.LocalAddr(), &raft.AppendEntriesRequest{Term: 7}, &resp)
+	assert.Error(t, err)
+}
+
+func TestParseInitialCluster(t *testing.T) {
+	servers, err := ParseInitialCluster([]string{"node1=localhost:7000", " node2 = localhost:7001 "})
+	assert.NoError(t, err)
+	assert.Equal(t, []raft.Server{
+		{Suffrage: raft.Voter, ID: "node1", Address: "localhost:7000"},
+		{Suffrage: raft.Voter, ID: "node2", Address: "localhost:7001"},
+	}, servers)
+
+	for _, members := range [][]string{
+		{"node1"},
+		{"=localhost:7000"},
+		{"node1="},
+		{"node1=localhost:7000", "node1=localhost:7001"},
+		{"node1=localhost:7000", "node2=localhost:7000"},
+	} {
+		_, err := ParseInitialCluster(members)
+		assert.Error(t, err, "%v", members)
+	}
+}
+
+func TestInitialClusterBootstrap(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// Reserve a port for each member
+	var members []string
+	for i := 1; i <= 3; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		members = append(members, fmt.Sprintf("node%d=%s", i, lis.Addr()))
+		_ = lis.Close()
+	}
+	initialCluster, err := ParseInitialCluster(members)
+	assert.NoError(t, err)
+
+	start := func(nodeID string, initialCluster []raft.Server) (*Raft, *raft.NetworkTransport, *badger.DB, error) {
+		port := 0
+		for _, server := range initialCluster {
+			if string(server.ID) == nodeID {
+				_, p, _ := net.SplitHostPort(string(server.Address))
+				port, _ = strconv.Atoi(p)
+			}
+		}
+		badgerOpts := badger.DefaultOptions(filepath.Join(dataDir, nodeID, "badger"))
+		badgerOpts.Logger = nil
+		db, err := badger.Open(badgerOpts)
+		assert.NoError(t, err)
+		node, transport, err := NewRaftNode(RaftNodeOptions{
+			NodeID:           nodeID,
+			Host:             "127.0.0.1",
+			Port:             port,
+			DataDir:          dataDir,
+			MaxSnapshots:     1,
+			HeartbeatTimeout: "500ms",
+			ElectionTimeout:  "500ms",
+			CommitTimeout:    "5ms",
+			DB:               db,
+			InitialCluster:   initialCluster,
+		})
+		if err != nil {
+			_ = db.Close()
+		}
+		return node, transport, db, err
+	}
+
+	// A node outside the initial cluster refuses to start
+	_, _, _, err = start("node4", initialCluster)
+	assert.ErrorContains(t, err, "not in the initial cluster")
+
+	var nodes []*Raft
+	for i := 1; i <= 3; i++ {
+		node, transport, db, err := start(fmt.Sprintf("node%d", i), initialCluster)
+		assert.NoError(t, err)
+		nodes = append(nodes, node)
+		defer stopDurableNode(t, node, transport, db)
+	}
+
+	var leader *Raft
+	assert.Eventually(t, func() bool {
+		for _, node := range nodes {
+			if node.GetRaft().State() == raft.Leader {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 50*time.Millisecond, "the initial cluster should elect a leader")
+	future := leader.GetRaft().GetConfiguration()
+	assert.NoError(t, future.Error())
+	assert.Len(t, future.Configuration().Servers, 3)
+}
+
+func TestBootstrapWithExistingState(t *testing.T) {
+	// A node whose stored configuration does not include it
+	_, transport := raft.NewInmemTransport("")
+	config := raft.DefaultConfig()
+	config.LocalID = "node1"
+	store := raft.NewInmemStore()
+	snapshots := raft.NewInmemSnapshotStore()
+	other := raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter, ID: "node2", Address: "node2"}}}
+	assert.NoError(t, raft.BootstrapCluster(config, store, store, snapshots, transport, other))
+	r, err := raft.NewRaft(config, &raft.MockFSM{}, store, store, snapshots, transport)
+	assert.NoError(t, err)
+	defer func() { _ = r.Shutdown().Error() }()
+
+	own := raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter, ID: "node1", Address: transport.LocalAddr()}}}
+	err = bootstrap(r, "node1", own, true)
+	assert.ErrorContains(t, err, "not a member")
+
+	// Bootstrapping over existing state is refused by raft itself
+	err = bootstrap(r, "node1", own, false)
+	assert.ErrorContains(t, err, "failed to bootstrap cluster")
+}
+
+func TestAutoJoin(t *testing.T) {
+	leader, leaderDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(leaderDir) }()
+	assert.Eventually(t, func() bool {
+		return leader.GetRaft().State() == raft.Leader
+	}, 3*time.Second, 100*time.Millisecond, "Node1 should become leader")
+
+	// The leader refuses the first attempt, as while it is still starting
+	attempts := 0
+	leaderHTTP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/raft/join", r.URL.Path)
+		assert.Equal(t, "Bearer join-token", r.Header.Get("Authorization"))
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var req RequestJoin
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if _, err := leader.JoinRaftHandler(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer leaderHTTP.Close()
+
+	// A follower redirects to the leader
+	followerHTTP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, leaderHTTP.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer followerHTTP.Close()
+
+	// And one seed is down
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	node, nodeDir, nodeAddr := setupTestRaft(t, "node2")
+	defer func() { _ = os.RemoveAll(nodeDir) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := node.AutoJoin(ctx, RequestJoin{NodeID: "node2", RaftAddress: string(nodeAddr)}, JoinOptions{
+		Seeds:         []string{strings.TrimPrefix(down.URL, "http://"), followerHTTP.URL},
+		Token:         "join-token",
+		RetryInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	assert.Eventually(t, func() bool {
+		leaderAddr, _ := node.GetRaft().LeaderWithID()
+		return leaderAddr != ""
+	}, 3*time.Second, 50*time.Millisecond, "node2 should hear from the leader")
+
+	// Once in the cluster, AutoJoin returns without contacting the seeds
+	assert.NoError(t, node.AutoJoin(ctx, RequestJoin{NodeID: "node2"}, JoinOptions{Seeds: []string{down.URL}}))
+	assert.Equal(t, 2, attempts)
+
+	// Without seeds there is nothing to do
+	assert.Error(t, node.AutoJoin(ctx, RequestJoin{NodeID: "node2"}, JoinOptions{}))
+}
+
+func TestSuffrage(t *testing.T) {
+	leader, leaderDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(leaderDir) }()
+	assert.Eventually(t, func() bool {
+		return leader.GetRaft().State() == raft.Leader
+	}, 3*time.Second, 100*time.Millisecond, "Node1 should become leader")
+
+	suffrageOf := func(nodeID string) raft.ServerSuffrage {
+		for _, server := range leader.GetRaft().GetConfiguration().Configuration().Servers {
+			if server.ID == raft.ServerID(nodeID) {
+				return server.Suffrage
+			}
+		}
+		return raft.Staging
+	}
+
+	replica, replicaDir, replicaAddr := setupTestRaft(t, "node2")
+	defer func() { _ = os.RemoveAll(replicaDir) }()
+
+	_, err := leader.JoinRaftHandler(RequestJoin{NodeID: "node2", RaftAddress: string(replicaAddr), Suffrage: "observer"})
+	assert.ErrorContains(t, err, "invalid suffrage")
+
+	success, err := leader.JoinRaftHandler(RequestJoin{NodeID: "node2", RaftAddress: string(replicaAddr), Suffrage: SuffrageNonvoter})
+	assert.NoError(t, err)
+	assert.True(t, success)
+	assert.Equal(t, raft.Nonvoter, suffrageOf("node2"))
+
+	// A non-voter replicates without counting towards quorum
+	stats, err := leader.StatsRaftHandler()
+	assert.NoError(t, err)
+	assert.Equal(t, "voter", stats["suffrage"])
+	assert.Equal(t, "0", stats["num_peers"])
+	assert.Equal(t, "1", stats["num_voters"])
+	assert.Equal(t, "1", stats["num_nonvoters"])
+	assert.Equal(t, "node1=voter,node2=nonvoter", stats["members"])
+	assert.Eventually(t, func() bool {
+		leaderAddr, _ := replica.GetRaft().LeaderWithID()
+		return leaderAddr != ""
+	}, 3*time.Second, 50*time.Millisecond, "node2 should hear from the leader")
+
+	// The last voter cannot be demoted
+	_, err = leader.DemoteRaftHandler(RequestSuffrage{NodeID: "node1", Force: true})
+	assert.ErrorContains(t, err, "last voter")
+
+	success, err = leader.PromoteRaftHandler(RequestSuffrage{NodeID: "node2"})
+	assert.NoError(t, err)
+	assert.True(t, success)
+	assert.Equal(t, raft.Voter, suffrageOf("node2"))
+
+	// Demoting below the minimum voters needs force
+	_, err = leader.DemoteRaftHandler(RequestSuffrage{NodeID: "node2"})
+	assert.ErrorContains(t, err, "below the minimum of 3")
+	assert.Equal(t, raft.Voter, suffrageOf("node2"))
+
+	success, err = leader.DemoteRaftHandler(RequestSuffrage{NodeID: "node2", Force: true})
+	assert.NoError(t, err)
+	assert.True(t, success)
+	assert.Equal(t, raft.Nonvoter, suffrageOf("node2"))
+
+	_, err = leader.PromoteRaftHandler(RequestSuffrage{NodeID: "node9"})
+	assert.ErrorContains(t, err, "not a member")
+	_, err = replica.PromoteRaftHandler(RequestSuffrage{NodeID: "node2"})
+	assert.ErrorContains(t, err, "not the leader")
+}
+
+func TestMembers(t *testing.T) {
+	dataDir := t.TempDir()
+	leader, leaderTransport, leaderDB := startDurableNode(t, dataDir, 0)
+	defer stopDurableNode(t, leader, leaderTransport, leaderDB)
+
+	badgerOpts := badger.DefaultOptions(filepath.Join(dataDir, "node2", "badger"))
+	badgerOpts.Logger = nil
+	followerDB, err := badger.Open(badgerOpts)
+	assert.NoError(t, err)
+	follower, followerTransport, err := NewRaftNode(RaftNodeOptions{
+		NodeID:           "node2",
+		Host:             "localhost",
+		DataDir:          dataDir,
+		MaxSnapshots:     1,
+		HeartbeatTimeout: "500ms",
+		ElectionTimeout:  "500ms",
+		CommitTimeout:    "5ms",
+		DB:               followerDB,
+	})
+	assert.NoError(t, err)
+	defer stopDurableNode(t, follower, followerTransport, followerDB)
+
+	_, err = leader.JoinRaftHandler(RequestJoin{
+		NodeID:      "node2",
+		RaftAddress: string(followerTransport.LocalAddr()),
+		Suffrage:    SuffrageNonvoter,
+	})
+	assert.NoError(t, err)
+
+	cmd, err := json.Marshal(fsm.CommandPayload{Operation: "SET", Key: "members-key", Value: "v"})
+	assert.NoError(t, err)
+	assert.NoError(t, leader.GetRaft().Apply(cmd, 5*time.Second).Error())
+
+	// The leader reports how far the follower has replicated
+	var members []Member
+	assert.Eventually(t, func() bool {
+		members, err = leader.MembersRaftHandler()
+		assert.NoError(t, err)
+		return len(members) == 2 && members[1].Progress != nil &&
+			members[1].Progress.MatchIndex == leader.GetRaft().LastIndex()
+	}, 5*time.Second, 50*time.Millisecond, "node2 should catch up")
+	assert.Equal(t, Member{ID: "node1", Address: string(leaderTransport.LocalAddr()), Suffrage: SuffrageVoter, Leader: true}, members[0])
+	assert.Equal(t, "node2", members[1].ID)
+	assert.Equal(t, SuffrageNonvoter, members[1].Suffrage)
+	assert.False(t, members[1].Leader)
+	assert.Equal(t, uint64(0), members[1].Progress.Lag)
+	assert.WithinDuration(t, time.Now(), members[1].Progress.LastContact, 5*time.Second)
+
+	// Followers list the members without progress
+	members, err = follower.MembersRaftHandler()
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+	assert.True(t, members[0].Leader)
+	assert.Nil(t, members[0].Progress)
+	assert.Nil(t, members[1].Progress)
+}
+
+func TestReplicationProgress(t *testing.T) {
+	p := newReplicationProgress()
+	p.record("node2", 2, 10)
+	// An older response arriving late does not move the match index back
+	p.record("node2", 2, 8)
+	progress, ok := p.get("node2", 2)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), progress.matchIndex)
+
+	// Progress from another term is not reported
+	_, ok = p.get("node2", 3)
+	assert.False(t, ok)
+	p.record("node2", 3, 4)
+	progress, ok = p.get("node2", 3)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), progress.matchIndex)
+}
@@ -2,31 +2,133 @@ package consensus
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/raft"
 )
 
+// DefaultMinVoters is the number of voters a drop may not go below without
+// force. Three voters tolerate the failure of one.
+const DefaultMinVoters = 3
+
+// leaderWaitTimeout bounds how long a drop waits for a new leader after
+// handing over leadership.
+const leaderWaitTimeout = 5 * time.Second
+
 // RequestDrop represents the payload for removing a node from the Raft cluster.
 type RequestDrop struct {
 	NodeID string
+	// Force allows dropping a voter even if fewer than the minimum voters
+	// remain. The last voter can never be dropped.
+	Force bool
 }
 
-func (r *Raft) DropRaftHandler(form RequestDrop) (bool, error) {
-	nodeID := form.NodeID
+// DropResult describes what a drop changed.
+type DropResult struct {
+	NodeID string `json:"node_id"`
+	// Suffrage is the suffrage the node had before the drop
+	Suffrage string `json:"suffrage"`
+	// Removed is set once the node is out of the configuration
+	Removed bool `json:"removed"`
+	// TransferredTo is set when this leader dropped itself: it handed
+	// leadership to that node instead, which must complete the drop.
+	TransferredTo string `json:"transferred_to,omitempty"`
+	// Forced is set when the drop went below the minimum voters
+	Forced bool `json:"forced,omitempty"`
+	// Voters and Nonvoters count the members left after the drop
+	Voters    int `json:"voters"`
+	Nonvoters int `json:"nonvoters"`
+}
 
-	if r.GetRaft().State() != raft.Leader {
-		return false, fmt.Errorf("not the leader")
+// DropRaftHandler removes a node from the cluster. Dropping a voter is
+// refused if fewer than the minimum voters would remain, unless req.Force is
+// set. A leader asked to drop itself first hands leadership to another
+// voter, since removing itself would leave the cluster without a leader
+// until an election; the returned result names the new leader, to which the
+// drop has to be sent again.
+func (r *Raft) DropRaftHandler(req RequestDrop) (*DropResult, error) {
+	server, err := r.member(req.NodeID)
+	if err != nil {
+		return nil, err
 	}
 
-	configFuture := r.GetRaft().GetConfiguration()
-	if err := configFuture.Error(); err != nil {
-		return false, fmt.Errorf("failed to get raft configuration: %w", err)
+	voters, nonvoters := 0, 0
+	for _, s := range r.GetRaft().GetConfiguration().Configuration().Servers {
+		if s.Suffrage == raft.Voter {
+			voters++
+		} else {
+			nonvoters++
+		}
 	}
 
-	future := r.GetRaft().RemoveServer(raft.ServerID(nodeID), 0, 0)
+	result := &DropResult{
+		NodeID:    req.NodeID,
+		Suffrage:  suffrageName(server.Suffrage),
+		Voters:    voters,
+		Nonvoters: nonvoters,
+	}
+	if server.Suffrage == raft.Voter {
+		if voters <= 1 {
+			return nil, fmt.Errorf("cannot drop node %s: it is the last voter", req.NodeID)
+		}
+		if voters-1 < r.minVoterCount() {
+			if !req.Force {
+				return nil, fmt.Errorf("cannot drop node %s: %d voters would remain, below the minimum of %d; use force to drop it anyway",
+					req.NodeID, voters-1, r.minVoterCount())
+			}
+			result.Forced = true
+		}
+	}
+
+	if server.ID == r.localServerID() {
+		newLeader, err := r.handOffLeadership(server.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error transferring leadership before dropping node %s: %w", req.NodeID, err)
+		}
+		result.TransferredTo = string(newLeader)
+		return result, nil
+	}
+
+	future := r.GetRaft().RemoveServer(server.ID, 0, 0)
 	if err := future.Error(); err != nil {
-		return false, fmt.Errorf("error removing existing node %s: %w", nodeID, err)
+		return nil, fmt.Errorf("error removing existing node %s: %w", req.NodeID, err)
+	}
+	if err := r.deleteHTTPAddress(req.NodeID); err != nil {
+		return nil, err
 	}
 
-	return true, nil
+	result.Removed = true
+	if server.Suffrage == raft.Voter {
+		result.Voters--
+	} else {
+		result.Nonvoters--
+	}
+	return result, nil
+}
+
+// minVoterCount returns the minimum voters a drop keeps without force.
+func (r *Raft) minVoterCount() int {
+	if r.minVoters > 0 {
+		return r.minVoters
+	}
+	return DefaultMinVoters
+}
+
+// handOffLeadership transfers leadership of this node, localID, to another
+// voter and waits until this node learns the new leader.
+func (r *Raft) handOffLeadership(localID raft.ServerID) (raft.ServerID, error) {
+	if err := r.GetRaft().LeadershipTransfer().Error(); err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(leaderWaitTimeout)
+	for {
+		if _, id := r.GetRaft().LeaderWithID(); id != "" && id != localID {
+			return id, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no new leader after %s", leaderWaitTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
@@ -10,6 +10,12 @@ import (
 type RequestJoin struct {
 	NodeID      string
 	RaftAddress string
+	// HTTPAddress is the host:port of the joining node's HTTP API. It is
+	// published through Raft so that followers can forward writes to
+	// whichever node is leader.
+	HTTPAddress string
+	// Suffrage is SuffrageVoter (the default) or SuffrageNonvoter
+	Suffrage string
 }
 
 // JoinRaftHandler handles the join raft request.
@@ -26,9 +32,25 @@ func (r *Raft) JoinRaftHandler(req RequestJoin) (bool, error) {
 		return false, fmt.Errorf("failed to get raft configuration: %w", err)
 	}
 
-	f := r.GetRaft().AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0)
-	if f.Error() != nil {
-		return false, fmt.Errorf("error adding voter: %w", f.Error())
+	switch req.Suffrage {
+	case "", SuffrageVoter:
+		f := r.GetRaft().AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0)
+		if f.Error() != nil {
+			return false, fmt.Errorf("error adding voter: %w", f.Error())
+		}
+	case SuffrageNonvoter:
+		f := r.GetRaft().AddNonvoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0)
+		if f.Error() != nil {
+			return false, fmt.Errorf("error adding non-voter: %w", f.Error())
+		}
+	default:
+		return false, fmt.Errorf("invalid suffrage %q", req.Suffrage)
+	}
+
+	if req.HTTPAddress != "" {
+		if err := r.setHTTPAddress(nodeID, req.HTTPAddress); err != nil {
+			return false, err
+		}
 	}
 
 	return true, nil
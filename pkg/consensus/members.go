@@ -0,0 +1,72 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Member is a server in the cluster configuration.
+type Member struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+	// Progress is only reported by the leader, for the other members
+	Progress *MemberProgress `json:"progress,omitempty"`
+}
+
+// MemberProgress is the replication progress of a follower as seen by the
+// leader.
+type MemberProgress struct {
+	// LastContact is when the follower last answered the leader; zero if it
+	// has not answered in the current term
+	LastContact time.Time `json:"last_contact"`
+	// MatchIndex is the last log index known to be stored on the follower
+	MatchIndex uint64 `json:"match_index"`
+	// Lag is how many entries the follower is behind the leader's log
+	Lag uint64 `json:"lag"`
+}
+
+// MembersRaftHandler lists the servers of the latest configuration, sorted
+// by ID.
+func (r *Raft) MembersRaftHandler() ([]Member, error) {
+	configFuture := r.GetRaft().GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, fmt.Errorf("failed to get raft configuration: %w", err)
+	}
+
+	_, leaderID := r.GetRaft().LeaderWithID()
+	isLeader := r.GetRaft().State() == raft.Leader
+	term := r.GetRaft().CurrentTerm()
+	lastIndex := r.GetRaft().LastIndex()
+
+	servers := configFuture.Configuration().Servers
+	members := make([]Member, 0, len(servers))
+	for _, server := range servers {
+		member := Member{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: suffrageName(server.Suffrage),
+			Leader:   server.ID == leaderID,
+		}
+		if isLeader && !member.Leader && r.progress != nil {
+			progress := &MemberProgress{Lag: lastIndex}
+			if p, ok := r.progress.get(server.ID, term); ok {
+				progress.LastContact = p.lastContact
+				progress.MatchIndex = p.matchIndex
+				if p.matchIndex < lastIndex {
+					progress.Lag = lastIndex - p.matchIndex
+				} else {
+					progress.Lag = 0
+				}
+			}
+			member.Progress = progress
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members, nil
+}
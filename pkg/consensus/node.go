@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"time"
@@ -9,7 +10,10 @@ import (
 	"github.com/dgraph-io/badger/v4"
 	"github.com/hashicorp/raft"
 
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
 	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/storage"
+	"github.com/subash-0044/beaver-vault/pkg/tlsutil"
 )
 
 // RaftNodeOptions holds all options needed to create a Raft node
@@ -24,6 +28,22 @@ type RaftNodeOptions struct {
 	CommitTimeout    string
 	DB               *badger.DB
 	Bootstrap        bool
+	// InitialCluster, if set, is the configuration every node listed in it
+	// bootstraps with. It implies Bootstrap.
+	InitialCluster []raft.Server
+	// MinVoters is the number of voters a drop keeps without force. Zero
+	// means DefaultMinVoters.
+	MinVoters int
+	// Watcher, if set, receives every change the FSM applies
+	Watcher *fsm.Watcher
+	// Keyring, if set, lets the FSM decrypt sealed values
+	Keyring *encryption.Keyring
+	// EncryptionKey, if set, encrypts the Raft log store files
+	EncryptionKey []byte
+	// TLS, if set, runs the Raft transport over mutual TLS
+	TLS *tlsutil.Reloader
+	// OnApply, if set, is called after the FSM applies each command
+	OnApply func(operation string, err error, took time.Duration)
 }
 
 // NewRaftNode initializes and returns a consensus.Raft and the underlying transport
@@ -45,44 +65,105 @@ func NewRaftNode(opts RaftNodeOptions) (*Raft, *raft.NetworkTransport, error) {
 		return nil, nil, fmt.Errorf("invalid CommitTimeout: %v", err)
 	}
 
-	// Create Raft storage
-	raftDir := filepath.Join(opts.DataDir, "raft")
-	if mkdirErr := os.MkdirAll(raftDir, 0755); mkdirErr != nil {
-		return nil, nil, fmt.Errorf("failed to create Raft directory: %v", mkdirErr)
+	// All Raft state lives under the per-node directory, so that a restarted
+	// node comes back with its term, vote, log and snapshots intact and nodes
+	// sharing a data directory keep apart.
+	nodeDir := filepath.Join(opts.DataDir, opts.NodeID)
+	if legacyDir := filepath.Join(opts.DataDir, "raft", "snapshots"); dirExists(legacyDir) {
+		log.Printf("Ignoring snapshots in %s, which is shared by every node; snapshots are now kept in %s",
+			legacyDir, filepath.Join(nodeDir, "snapshots"))
 	}
 
-	logStore := raft.NewInmemStore()
-	stableStore := raft.NewInmemStore()
-	snapshotStore, err := raft.NewFileSnapshotStore(raftDir, opts.MaxSnapshots, nil)
+	raftStore, err := storage.NewEncryptedRaftStore(filepath.Join(nodeDir, "raft"), opts.EncryptionKey)
 	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Raft log store: %v", err)
+	}
+
+	snapshotStore, err := raft.NewFileSnapshotStore(nodeDir, opts.MaxSnapshots, nil)
+	if err != nil {
+		_ = raftStore.Close()
 		return nil, nil, fmt.Errorf("failed to create snapshot store: %v", err)
 	}
 
 	addr := fmt.Sprintf("%s:%d", opts.Host, opts.Port)
-	transport, err := raft.NewTCPTransport(addr, nil, 3, 10*time.Second, nil)
+	transport, err := newTransport(addr, opts.TLS)
 	if err != nil {
+		_ = raftStore.Close()
 		return nil, nil, fmt.Errorf("failed to create Raft transport: %v", err)
 	}
 
-	fsmStore := fsm.New(opts.DB)
+	fsmStore := fsm.NewWithOptions(opts.DB, fsm.Options{
+		Watcher: opts.Watcher,
+		Keyring: opts.Keyring,
+		OnApply: opts.OnApply,
+	})
 
-	r, err := raft.NewRaft(raftConfig, fsmStore, logStore, stableStore, snapshotStore, transport)
+	// Checked before NewRaft, which restores the latest snapshot
+	hasState, err := raft.HasExistingState(raftStore, raftStore, snapshotStore)
 	if err != nil {
+		_ = transport.Close()
+		_ = raftStore.Close()
+		return nil, nil, fmt.Errorf("failed to check existing Raft state: %v", err)
+	}
+
+	// The FSM skips entries up to the index it last applied, which is kept
+	// with the data. Without the Raft state those entries came from, a new
+	// log would reuse their indexes and its entries would be skipped.
+	if !hasState {
+		applied, err := fsm.AppliedIndex(opts.DB)
+		if err != nil {
+			_ = transport.Close()
+			_ = raftStore.Close()
+			return nil, nil, fmt.Errorf("failed to read applied index: %v", err)
+		}
+		if applied > 0 {
+			_ = transport.Close()
+			_ = raftStore.Close()
+			return nil, nil, fmt.Errorf("the data store holds entries applied up to index %d but there is no Raft state in %s; "+
+				"restore the Raft state, or remove the data store to start over", applied, nodeDir)
+		}
+	}
+
+	var configuration raft.Configuration
+	if opts.Bootstrap || len(opts.InitialCluster) > 0 {
+		configuration, err = bootstrapConfiguration(raftConfig.LocalID, transport.LocalAddr(), opts.InitialCluster)
+		if err != nil {
+			_ = transport.Close()
+			_ = raftStore.Close()
+			return nil, nil, err
+		}
+	}
+
+	// The leader learns the progress of followers from their responses
+	progress := newReplicationProgress()
+	r, err := raft.NewRaft(raftConfig, fsmStore, raftStore, raftStore, snapshotStore,
+		&progressTransport{NetworkTransport: transport, progress: progress})
+	if err != nil {
+		_ = transport.Close()
+		_ = raftStore.Close()
 		return nil, nil, fmt.Errorf("failed to create Raft: %v", err)
 	}
 
 	// Bootstrap the cluster if configured
-	if opts.Bootstrap {
-		configuration := raft.Configuration{
-			Servers: []raft.Server{
-				{
-					ID:      raftConfig.LocalID,
-					Address: transport.LocalAddr(),
-				},
-			},
+	if len(configuration.Servers) > 0 {
+		if err := bootstrap(r, raftConfig.LocalID, configuration, hasState); err != nil {
+			_ = r.Shutdown().Error()
+			_ = transport.Close()
+			_ = raftStore.Close()
+			return nil, nil, err
 		}
-		r.BootstrapCluster(configuration)
 	}
 
-	return NewRaftObj(r), transport, nil
+	node := NewRaftObj(r)
+	node.store = raftStore
+	node.hasState = hasState || len(configuration.Servers) > 0
+	node.localID = raftConfig.LocalID
+	node.progress = progress
+	node.minVoters = opts.MinVoters
+	return node, transport, nil
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
 }
@@ -0,0 +1,150 @@
+package consensus
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// peerProgress is what a leader last heard from a follower.
+type peerProgress struct {
+	term        uint64
+	lastContact time.Time
+	matchIndex  uint64
+}
+
+// replicationProgress records the replication progress of every follower
+// from the responses the leader receives, since raft does not expose it.
+type replicationProgress struct {
+	mu    sync.RWMutex
+	peers map[raft.ServerID]peerProgress
+}
+
+func newReplicationProgress() *replicationProgress {
+	return &replicationProgress{peers: make(map[raft.ServerID]peerProgress)}
+}
+
+// record notes a successful response from id to a request sent in term.
+// matchIndex is 0 when the request did not tell which entries the follower
+// has, as for heartbeats.
+func (p *replicationProgress) record(id raft.ServerID, term, matchIndex uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prev := p.peers[id]
+	next := peerProgress{term: term, lastContact: time.Now(), matchIndex: matchIndex}
+	// Responses within a term may arrive out of order
+	if prev.term == term && prev.matchIndex > matchIndex {
+		next.matchIndex = prev.matchIndex
+	}
+	p.peers[id] = next
+}
+
+// get returns the progress of id recorded in term.
+func (p *replicationProgress) get(id raft.ServerID, term uint64) (peerProgress, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	progress, ok := p.peers[id]
+	if !ok || progress.term != term {
+		return peerProgress{}, false
+	}
+	return progress, true
+}
+
+// progressTransport is a raft.Transport that records the replication
+// progress of followers as a leader sends them entries. Every other method,
+// including the optional transport interfaces, comes from the embedded
+// transport.
+type progressTransport struct {
+	*raft.NetworkTransport
+	progress *replicationProgress
+}
+
+// AppendEntries implements raft.Transport.
+func (t *progressTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	err := t.NetworkTransport.AppendEntries(id, target, args, resp)
+	if err == nil {
+		t.recordAppend(id, args, resp)
+	}
+	return err
+}
+
+// AppendEntriesPipeline implements raft.Transport.
+func (t *progressTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	pipeline, err := t.NetworkTransport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	p := &progressPipeline{
+		AppendPipeline: pipeline,
+		transport:      t,
+		id:             id,
+		consumer:       make(chan raft.AppendFuture),
+		stopCh:         make(chan struct{}),
+	}
+	go p.relay()
+	return p, nil
+}
+
+// InstallSnapshot implements raft.Transport.
+func (t *progressTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	err := t.NetworkTransport.InstallSnapshot(id, target, args, resp, data)
+	if err == nil && resp.Success {
+		t.progress.record(id, args.Term, args.LastLogIndex)
+	}
+	return err
+}
+
+func (t *progressTransport) recordAppend(id raft.ServerID, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) {
+	if !resp.Success {
+		return
+	}
+	matchIndex := args.PrevLogEntry
+	if n := len(args.Entries); n > 0 {
+		matchIndex = args.Entries[n-1].Index
+	}
+	t.progress.record(id, args.Term, matchIndex)
+}
+
+// progressPipeline records the responses of a pipeline as raft consumes
+// them.
+type progressPipeline struct {
+	raft.AppendPipeline
+	transport *progressTransport
+	id        raft.ServerID
+	consumer  chan raft.AppendFuture
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// Consumer implements raft.AppendPipeline.
+func (p *progressPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.consumer
+}
+
+// Close implements raft.AppendPipeline.
+func (p *progressPipeline) Close() error {
+	p.closeOnce.Do(func() { close(p.stopCh) })
+	return p.AppendPipeline.Close()
+}
+
+func (p *progressPipeline) relay() {
+	for {
+		select {
+		case future := <-p.AppendPipeline.Consumer():
+			if future.Error() == nil {
+				p.transport.recordAppend(p.id, future.Request(), future.Response())
+			}
+			select {
+			case p.consumer <- future:
+			case <-p.stopCh:
+				return
+			}
+		case <-p.stopCh:
+			return
+		}
+	}
+}
@@ -1,17 +1,37 @@
 package consensus
 
 import (
+	"io"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
 	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/storage"
 )
 
 // handler struct handler
 type Raft struct {
-	raft *raft.Raft
+	raft     *raft.Raft
+	store    io.Closer
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	// hasState is set when the node started with Raft state or bootstrapped
+	hasState bool
+	// localID is the ID of this node, if created by NewRaftNode
+	localID raft.ServerID
+	// progress tracks followers while this node leads, if created by
+	// NewRaftNode
+	progress *replicationProgress
+	// minVoters is the number of voters a drop keeps without force, or 0
+	// for DefaultMinVoters
+	minVoters int
 }
 
 func NewRaftObj(raft *raft.Raft) *Raft {
 	return &Raft{
-		raft: raft,
+		raft:   raft,
+		stopCh: make(chan struct{}),
 	}
 }
 
@@ -19,7 +39,56 @@ func (r *Raft) GetRaft() *raft.Raft {
 	return r.raft
 }
 
-// StatsRaftHandler get raft status
+// LogStoreDB returns the Badger database of the Raft log and stable store,
+// or nil if the node was not created by NewRaftNode.
+func (r *Raft) LogStoreDB() *badger.DB {
+	if store, ok := r.store.(*storage.RaftStore); ok {
+		return store.DB
+	}
+	return nil
+}
+
+// HasExistingState reports whether the node started with Raft state or
+// bootstrapped a cluster. A node without state has to be joined.
+func (r *Raft) HasExistingState() bool {
+	return r.hasState
+}
+
+// Close stops background work and releases the durable log and stable
+// store. It must be called only after the underlying raft.Raft has been
+// shut down.
+func (r *Raft) Close() error {
+	r.stopOnce.Do(func() { close(r.stopCh) })
+	if r.store == nil {
+		return nil
+	}
+	return r.store.Close()
+}
+
+// TransferLeadership hands leadership to another voter when this node is
+// the leader, so the cluster does not wait for an election timeout after it
+// stops. It does nothing on a follower or when there is no other voter.
+func (r *Raft) TransferLeadership() error {
+	if r.raft.State() != raft.Leader {
+		return nil
+	}
+
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	localID := r.localServerID()
+	for _, server := range future.Configuration().Servers {
+		if server.ID != localID && server.Suffrage == raft.Voter {
+			return r.raft.LeadershipTransfer().Error()
+		}
+	}
+	return nil
+}
+
+// StatsRaftHandler get raft status, with the suffrage of every member
 func (r *Raft) StatsRaftHandler() (map[string]string, error) {
-	return r.GetRaft().Stats(), nil
+	stats := r.GetRaft().Stats()
+	r.suffrageStats(stats)
+	return stats, nil
 }
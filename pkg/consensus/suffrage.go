@@ -0,0 +1,145 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// Suffrage values of RequestJoin. Non-voters replicate the log and serve
+// stale reads but take no part in elections or quorum.
+const (
+	SuffrageVoter    = "voter"
+	SuffrageNonvoter = "nonvoter"
+)
+
+// RequestSuffrage represents the payload for promoting or demoting a node.
+type RequestSuffrage struct {
+	NodeID string
+	// Force allows demoting a voter even if fewer than the minimum voters
+	// remain. The last voter can never be demoted.
+	Force bool
+}
+
+// suffrageName returns the name of s used in requests and stats.
+func suffrageName(s raft.ServerSuffrage) string {
+	switch s {
+	case raft.Voter:
+		return SuffrageVoter
+	case raft.Nonvoter:
+		return SuffrageNonvoter
+	default:
+		return strings.ToLower(s.String())
+	}
+}
+
+// PromoteRaftHandler makes a non-voter a voter. It waits for the change to
+// be committed, not for the node to catch up.
+func (r *Raft) PromoteRaftHandler(req RequestSuffrage) (bool, error) {
+	server, err := r.member(req.NodeID)
+	if err != nil {
+		return false, err
+	}
+	if server.Suffrage == raft.Voter {
+		return true, nil
+	}
+	if err := r.GetRaft().AddVoter(server.ID, server.Address, 0, 0).Error(); err != nil {
+		return false, fmt.Errorf("error promoting node %s: %w", req.NodeID, err)
+	}
+	return true, nil
+}
+
+// DemoteRaftHandler makes a voter a non-voter. Like a drop, it is refused if
+// fewer than the minimum voters would remain, unless req.Force is set. The
+// last voter cannot be demoted, since the cluster could no longer elect a
+// leader.
+func (r *Raft) DemoteRaftHandler(req RequestSuffrage) (bool, error) {
+	server, err := r.member(req.NodeID)
+	if err != nil {
+		return false, err
+	}
+	if server.Suffrage != raft.Voter {
+		return true, nil
+	}
+
+	voters := 0
+	for _, s := range r.GetRaft().GetConfiguration().Configuration().Servers {
+		if s.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	if voters <= 1 {
+		return false, fmt.Errorf("cannot demote node %s: it is the last voter", req.NodeID)
+	}
+	if voters-1 < r.minVoterCount() && !req.Force {
+		return false, fmt.Errorf("cannot demote node %s: %d voters would remain, below the minimum of %d; use force to demote it anyway",
+			req.NodeID, voters-1, r.minVoterCount())
+	}
+
+	if err := r.GetRaft().DemoteVoter(server.ID, 0, 0).Error(); err != nil {
+		return false, fmt.Errorf("error demoting node %s: %w", req.NodeID, err)
+	}
+	return true, nil
+}
+
+// member returns the server nodeID in the configuration of this leader.
+func (r *Raft) member(nodeID string) (raft.Server, error) {
+	if r.GetRaft().State() != raft.Leader {
+		return raft.Server{}, fmt.Errorf("not the leader")
+	}
+
+	configFuture := r.GetRaft().GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return raft.Server{}, fmt.Errorf("failed to get raft configuration: %w", err)
+	}
+	for _, server := range configFuture.Configuration().Servers {
+		if server.ID == raft.ServerID(nodeID) {
+			return server, nil
+		}
+	}
+	return raft.Server{}, fmt.Errorf("node %s is not a member of the cluster", nodeID)
+}
+
+// localServerID returns the ID of this node, or "" if it is unknown.
+func (r *Raft) localServerID() raft.ServerID {
+	if r.localID != "" {
+		return r.localID
+	}
+	if r.GetRaft().State() == raft.Leader {
+		_, id := r.GetRaft().LeaderWithID()
+		return id
+	}
+	return ""
+}
+
+// suffrageStats adds the suffrage of this node and of every member to
+// stats.
+func (r *Raft) suffrageStats(stats map[string]string) {
+	configFuture := r.GetRaft().GetConfiguration()
+	if configFuture.Error() != nil {
+		return
+	}
+	servers := configFuture.Configuration().Servers
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+
+	localID := r.localServerID()
+	stats["suffrage"] = "none"
+	voters, nonvoters := 0, 0
+	members := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if server.ID == localID {
+			stats["suffrage"] = suffrageName(server.Suffrage)
+		}
+		if server.Suffrage == raft.Voter {
+			voters++
+		} else {
+			nonvoters++
+		}
+		members = append(members, fmt.Sprintf("%s=%s", server.ID, suffrageName(server.Suffrage)))
+	}
+	stats["num_voters"] = fmt.Sprint(voters)
+	stats["num_nonvoters"] = fmt.Sprint(nonvoters)
+	stats["members"] = strings.Join(members, ",")
+}
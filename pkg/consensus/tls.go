@@ -0,0 +1,70 @@
+package consensus
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/tlsutil"
+)
+
+// tlsStreamLayer is a raft.StreamLayer that runs the Raft transport over
+// mutual TLS: peers must present a certificate signed by the cluster CA on
+// both ends of a connection.
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	certs     *tlsutil.Reloader
+}
+
+// newTLSStreamLayer listens on bindAddr. Certificates are taken from certs
+// for every new connection, so reloaded files apply without a restart.
+func newTLSStreamLayer(bindAddr string, certs *tlsutil.Reloader) (*tlsStreamLayer, error) {
+	if !certs.Mutual() {
+		return nil, fmt.Errorf("raft tls requires a ca file to verify peers")
+	}
+
+	listener, err := tls.Listen("tcp", bindAddr, certs.ServerConfig(true))
+	if err != nil {
+		return nil, err
+	}
+
+	// Like raft.NewTCPTransport, refuse to advertise an unspecified address
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok || addr.IP == nil || addr.IP.IsUnspecified() {
+		_ = listener.Close()
+		return nil, raft.ErrTransportShutdown
+	}
+	return &tlsStreamLayer{Listener: listener, advertise: addr, certs: certs}, nil
+}
+
+// Dial implements raft.StreamLayer.
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(string(address))
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), l.certs.ClientConfig(host))
+}
+
+// Addr implements net.Listener, returning the address peers reach us at.
+func (l *tlsStreamLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+// newTransport creates the Raft transport on addr, over mutual TLS when
+// certs is set.
+func newTransport(addr string, certs *tlsutil.Reloader) (*raft.NetworkTransport, error) {
+	if certs == nil {
+		return raft.NewTCPTransport(addr, nil, 3, 10*time.Second, nil)
+	}
+	layer, err := newTLSStreamLayer(addr, certs)
+	if err != nil {
+		return nil, err
+	}
+	return raft.NewNetworkTransport(layer, 3, 10*time.Second, nil), nil
+}
@@ -0,0 +1,204 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MasterKeyEnv is the environment variable holding the master key when no
+// key file is configured.
+const MasterKeyEnv = "BEAVER_MASTER_KEY"
+
+// KeySize is the size in bytes of master and data keys (AES-256).
+const KeySize = 32
+
+// ErrUnknownKey is returned when a value is sealed with a data key that has
+// not been added to the keyring.
+var ErrUnknownKey = errors.New("unknown data key")
+
+// LoadMasterKey reads the master key from path, or from MasterKeyEnv if path
+// is empty. The key is 32 raw bytes, or their hex or base64 encoding.
+func LoadMasterKey(path string) ([]byte, error) {
+	var raw []byte
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading master key file: %w", err)
+		}
+		raw = data
+	} else {
+		value, ok := os.LookupEnv(MasterKeyEnv)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("no master key file configured and %s is not set", MasterKeyEnv)
+		}
+		raw = []byte(value)
+	}
+	return ParseMasterKey(raw)
+}
+
+// ParseMasterKey decodes a master key given as 32 raw bytes, or as their hex
+// or base64 encoding.
+func ParseMasterKey(raw []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if len(raw) == KeySize {
+		return append([]byte{}, raw...), nil
+	}
+	return nil, fmt.Errorf("master key must be %d bytes, hex or base64 encoded", KeySize)
+}
+
+// DeriveKey derives a key for a single purpose from the master key, so the
+// master key itself is only ever used to wrap data keys.
+func DeriveKey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Keyring holds the data keys values are sealed with. Data keys are
+// generated at random and stored wrapped (encrypted) by the master key, so
+// only nodes that have the master key can use them.
+type Keyring struct {
+	master cipher.AEAD
+
+	mu   sync.RWMutex
+	keys map[string]cipher.AEAD
+}
+
+// NewKeyring creates an empty keyring for the given master key.
+func NewKeyring(master []byte) (*Keyring, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return &Keyring{
+		master: aead,
+		keys:   make(map[string]cipher.AEAD),
+	}, nil
+}
+
+// NewDataKey generates a data key, adds it to the keyring and returns its
+// id together with the key wrapped by the master key.
+func (k *Keyring) NewDataKey() (string, []byte, error) {
+	id := make([]byte, 8)
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+
+	keyID := hex.EncodeToString(id)
+	wrapped, err := seal(k.master, key, []byte(keyID))
+	if err != nil {
+		return "", nil, err
+	}
+	if err := k.addKey(keyID, key); err != nil {
+		return "", nil, err
+	}
+	return keyID, wrapped, nil
+}
+
+// AddDataKey unwraps a data key created by NewDataKey and adds it to the
+// keyring.
+func (k *Keyring) AddDataKey(id string, wrapped []byte) error {
+	key, err := open(k.master, wrapped, []byte(id))
+	if err != nil {
+		return fmt.Errorf("error unwrapping data key %s: %w", id, err)
+	}
+	return k.addKey(id, key)
+}
+
+// Has reports whether the data key id is in the keyring.
+func (k *Keyring) Has(id string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	_, ok := k.keys[id]
+	return ok
+}
+
+// Seal encrypts plaintext with the data key id. aad is authenticated but not
+// encrypted; the same aad must be passed to Open.
+func (k *Keyring) Seal(id string, plaintext, aad []byte) ([]byte, error) {
+	aead, err := k.key(id)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, plaintext, aad)
+}
+
+// Open decrypts a ciphertext produced by Seal with the data key id.
+func (k *Keyring) Open(id string, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := k.key(id)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext, aad)
+}
+
+func (k *Keyring) key(id string) (cipher.AEAD, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	return aead, nil
+}
+
+func (k *Keyring) addKey(id string, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	return nil
+}
+
+// newAEAD returns AES-256-GCM for key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, aad)
+}
@@ -0,0 +1,84 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMasterKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	dir := t.TempDir()
+
+	for name, content := range map[string][]byte{
+		"raw":    key,
+		"hex":    []byte(hex.EncodeToString(key) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(key)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, content, 0600))
+			loaded, err := LoadMasterKey(path)
+			assert.NoError(t, err)
+			assert.Equal(t, key, loaded)
+		})
+	}
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv(MasterKeyEnv, hex.EncodeToString(key))
+		loaded, err := LoadMasterKey("")
+		assert.NoError(t, err)
+		assert.Equal(t, key, loaded)
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		_, err := ParseMasterKey([]byte("c2hvcnQ="))
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyring(t *testing.T) {
+	master := bytes.Repeat([]byte{1}, KeySize)
+	keyring, err := NewKeyring(master)
+	require.NoError(t, err)
+
+	id, wrapped, err := keyring.NewDataKey()
+	require.NoError(t, err)
+	assert.True(t, keyring.Has(id))
+
+	sealed, err := keyring.Seal(id, []byte("secret"), []byte("app-a"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "secret")
+
+	plaintext, err := keyring.Open(id, sealed, []byte("app-a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), plaintext)
+
+	// The ciphertext is bound to the key it was sealed for
+	_, err = keyring.Open(id, sealed, []byte("app-b"))
+	assert.Error(t, err)
+
+	t.Run("Unwrap On Another Node", func(t *testing.T) {
+		other, err := NewKeyring(master)
+		require.NoError(t, err)
+		_, err = other.Open(id, sealed, []byte("app-a"))
+		assert.ErrorIs(t, err, ErrUnknownKey)
+
+		require.NoError(t, other.AddDataKey(id, wrapped))
+		plaintext, err := other.Open(id, sealed, []byte("app-a"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("secret"), plaintext)
+	})
+
+	t.Run("Wrong Master Key", func(t *testing.T) {
+		other, err := NewKeyring(bytes.Repeat([]byte{2}, KeySize))
+		require.NoError(t, err)
+		assert.Error(t, other.AddDataKey(id, wrapped))
+	})
+}
@@ -0,0 +1,143 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// BatchOpResult describes the outcome of one operation of a BATCH payload.
+type BatchOpResult struct {
+	Operation string `json:"op"`
+	Key       string `json:"key"`
+	// ModIndex is the mod index of a key written by a SET.
+	ModIndex uint64 `json:"mod_index,omitempty"`
+	// Error is set if the operation was skipped.
+	Error string `json:"error,omitempty"`
+	// Err is the error behind Error, such as a *ConflictError.
+	Err error `json:"-"`
+}
+
+// keyState is the state of a key as seen by the operations of a batch.
+type keyState struct {
+	exists bool
+	meta   KeyMeta
+}
+
+// applyBatch writes a list of SET and DELETE payloads at index with a single
+// Badger WriteBatch, checking their conditions at now. Unlike a TXN, a batch is not atomic: an operation that
+// is invalid or whose condition does not hold is skipped and reported in its
+// result while the others apply.
+func (f FSM) applyBatch(ops []CommandPayload, index, now uint64) ([]BatchOpResult, []Event, error) {
+	// Apply is the only writer, so the state read here stays valid until the
+	// batch is flushed. states tracks it across the operations of the batch.
+	txn := f.db.NewTransaction(false)
+	defer txn.Discard()
+	states := make(map[string]keyState)
+	stateOf := func(key string) (keyState, error) {
+		if state, ok := states[key]; ok {
+			return state, nil
+		}
+		var state keyState
+		_, err := getAt(txn, []byte(key), now)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return state, err
+		}
+		if state.exists = err == nil; state.exists {
+			if state.meta, err = readMetaAt(txn, key, now); err != nil {
+				return state, err
+			}
+		}
+		states[key] = state
+		return state, nil
+	}
+
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	results := make([]BatchOpResult, len(ops))
+	var events []Event
+	for i, op := range ops {
+		results[i] = BatchOpResult{
+			Operation: strings.ToUpper(strings.TrimSpace(op.Operation)),
+			Key:       op.Key,
+		}
+		fail := func(err error) {
+			results[i].Err = err
+			results[i].Error = err.Error()
+		}
+		if len(op.Key) == 0 {
+			fail(fmt.Errorf("key cannot be empty"))
+			continue
+		}
+
+		state, err := stateOf(op.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch results[i].Operation {
+		case "SET":
+			if op.Value == nil && op.Data == nil {
+				fail(fmt.Errorf("value cannot be empty"))
+				continue
+			}
+			data, valueType, err := EncodeValue(op)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if err := conditionError(op, state.meta, state.exists); err != nil {
+				fail(err)
+				continue
+			}
+
+			if !state.exists {
+				state.meta.CreateIndex = index
+			}
+			state.meta.ModIndex = index
+			state.meta.Type = valueType
+			state.meta.KeyID = op.KeyID
+			metaData, err := json.Marshal(state.meta)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if err := wb.SetEntry(expiringEntry([]byte(op.Key), data, op.ExpiresAt)); err != nil {
+				return nil, nil, err
+			}
+			if err := wb.SetEntry(expiringEntry(metaKey(op.Key), metaData, op.ExpiresAt)); err != nil {
+				return nil, nil, err
+			}
+			states[op.Key] = keyState{exists: true, meta: state.meta}
+			results[i].ModIndex = index
+			events = append(events, f.putEvent(op, index))
+		case "DELETE":
+			if err := conditionError(op, state.meta, state.exists); err != nil {
+				fail(err)
+				continue
+			}
+			if err := wb.Delete([]byte(op.Key)); err != nil {
+				return nil, nil, err
+			}
+			if err := wb.Delete(metaKey(op.Key)); err != nil {
+				return nil, nil, err
+			}
+			states[op.Key] = keyState{}
+			events = append(events, Event{Type: EventDelete, Key: op.Key, Index: index})
+		default:
+			fail(fmt.Errorf("unsupported batch operation %q", op.Operation))
+		}
+	}
+
+	// Written last, so it is only visible once the whole batch is
+	if err := setAppliedIndex(wb, index); err != nil {
+		return nil, nil, err
+	}
+	if err := wb.Flush(); err != nil {
+		return nil, nil, err
+	}
+	return results, events, nil
+}
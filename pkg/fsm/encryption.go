@@ -0,0 +1,159 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
+)
+
+// ActiveDataKey returns the id of the data key new values are sealed with,
+// or an empty string if no data key has been created yet.
+func ActiveDataKey(txn *badger.Txn) (string, error) {
+	item, err := txn.Get([]byte(activeKeyKey))
+	if err == badger.ErrKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &id)
+	})
+	return id, err
+}
+
+// LoadDataKey adds the data key id, as stored in txn, to keyring unless the
+// keyring already holds it.
+func LoadDataKey(txn *badger.Txn, keyring *encryption.Keyring, id string) error {
+	if keyring.Has(id) {
+		return nil
+	}
+
+	item, err := txn.Get([]byte(encryptionKeyPrefix + id))
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("data key %s does not exist", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	var wrapped []byte
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &wrapped)
+	})
+	if err != nil {
+		return err
+	}
+	return keyring.AddDataKey(id, wrapped)
+}
+
+// OpenValue returns the plaintext of a value stored under key. Values that
+// are not sealed are returned as is.
+func OpenValue(txn *badger.Txn, keyring *encryption.Keyring, key string, raw []byte, meta KeyMeta) ([]byte, error) {
+	if meta.KeyID == "" {
+		return raw, nil
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("value of key %s is encrypted and no master key is configured", key)
+	}
+	if err := LoadDataKey(txn, keyring, meta.KeyID); err != nil {
+		return nil, err
+	}
+	// The key name is authenticated, so a ciphertext cannot be moved to
+	// another key
+	return keyring.Open(meta.KeyID, raw, []byte(SealingName(key)))
+}
+
+// ReadValue decrypts a stored value if it is sealed and decodes it like
+// DecodeValue.
+func ReadValue(txn *badger.Txn, keyring *encryption.Keyring, key string, raw []byte, meta KeyMeta) (interface{}, error) {
+	plaintext, err := OpenValue(txn, keyring, key, raw, meta)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeValue(plaintext, meta)
+}
+
+// applyDataKey stores the wrapped data key of a KEY payload at index and
+// makes it the active key.
+func (f FSM) applyDataKey(payload CommandPayload, index uint64) error {
+	if payload.Key == "" || len(payload.Data) == 0 {
+		return fmt.Errorf("data key cannot be empty")
+	}
+
+	wrapped, err := json.Marshal(payload.Data)
+	if err != nil {
+		return err
+	}
+	id, err := json.Marshal(payload.Key)
+	if err != nil {
+		return err
+	}
+	return f.update(index, func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(encryptionKeyPrefix+payload.Key), wrapped); err != nil {
+			return err
+		}
+		return txn.Set([]byte(activeKeyKey), id)
+	})
+}
+
+// applyReencrypt replaces values with the resealed copies listed in a
+// REENCRYPT payload at index and returns how many it replaced. Each copy
+// carries the mod index of the value it was made from in CASIndex; a key
+// modified since, or expired at now, is skipped. Indexes and expiry are kept and no event is published, since
+// the value itself does not change.
+func (f FSM) applyReencrypt(ops []CommandPayload, index, now uint64) (int, error) {
+	txn := f.db.NewTransaction(false)
+	defer txn.Discard()
+
+	wb := f.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	resealed := 0
+	for _, op := range ops {
+		if op.Key == "" || op.KeyID == "" || op.CASIndex == nil {
+			continue
+		}
+
+		item, err := getAt(txn, []byte(op.Key), now)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		meta, err := readMetaAt(txn, op.Key, now)
+		if err != nil {
+			return 0, err
+		}
+		if meta.ModIndex != *op.CASIndex {
+			continue
+		}
+
+		meta.KeyID = op.KeyID
+		metaData, err := json.Marshal(meta)
+		if err != nil {
+			return 0, err
+		}
+		if err := wb.SetEntry(expiringEntry([]byte(op.Key), op.Data, item.ExpiresAt())); err != nil {
+			return 0, err
+		}
+		if err := wb.SetEntry(expiringEntry(metaKey(op.Key), metaData, item.ExpiresAt())); err != nil {
+			return 0, err
+		}
+		resealed++
+	}
+
+	if err := setAppliedIndex(wb, index); err != nil {
+		return 0, err
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return resealed, nil
+}
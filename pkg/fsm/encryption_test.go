@@ -0,0 +1,149 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
+)
+
+func TestFSM_SealedValues(t *testing.T) {
+	master := bytes.Repeat([]byte{3}, encryption.KeySize)
+	leaderKeys, err := encryption.NewKeyring(master)
+	require.NoError(t, err)
+
+	// The FSM has a keyring of its own and loads data keys from the store
+	keyring, err := encryption.NewKeyring(master)
+	require.NoError(t, err)
+	_, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+	w := NewWatcher(0)
+	f := NewWithOptions(db, Options{Watcher: w, Keyring: keyring}).(*FSM)
+
+	apply := func(index uint64, payload CommandPayload) *ApplyResponse {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		return f.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data}).(*ApplyResponse)
+	}
+	sealed := func(keyID, key string, value interface{}) CommandPayload {
+		plaintext, err := json.Marshal(value)
+		require.NoError(t, err)
+		data, err := leaderKeys.Seal(keyID, plaintext, []byte(key))
+		require.NoError(t, err)
+		return CommandPayload{Operation: "SET", Key: key, Data: data, KeyID: keyID}
+	}
+	read := func(db *badger.DB, keyring *encryption.Keyring, key string) (interface{}, []byte, KeyMeta) {
+		var value interface{}
+		var raw []byte
+		var meta KeyMeta
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(key))
+			if err != nil {
+				return err
+			}
+			if meta, err = ReadMeta(txn, key); err != nil {
+				return err
+			}
+			if raw, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+			value, err = ReadValue(txn, keyring, key, raw, meta)
+			return err
+		}))
+		return value, raw, meta
+	}
+
+	first, wrapped, err := leaderKeys.NewDataKey()
+	require.NoError(t, err)
+	require.NoError(t, apply(1, CommandPayload{Operation: "KEY", Key: first, Data: wrapped}).Error)
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		active, err := ActiveDataKey(txn)
+		assert.Equal(t, first, active)
+		return err
+	}))
+
+	sub, err := w.Subscribe(func(string) bool { return true }, 0)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.NoError(t, apply(2, sealed(first, "secret", "s3cr3t")).Error)
+	require.NoError(t, apply(3, CommandPayload{Operation: "SET", Key: "plain", Value: "text"}).Error)
+
+	value, raw, meta := read(db, keyring, "secret")
+	assert.Equal(t, "s3cr3t", value)
+	assert.NotContains(t, string(raw), "s3cr3t")
+	assert.Equal(t, first, meta.KeyID)
+	assert.Equal(t, uint64(2), meta.ModIndex)
+
+	// Watchers and transaction guards see the plaintext
+	event := <-sub.Events()
+	assert.Equal(t, "s3cr3t", event.Value)
+	resp := apply(4, CommandPayload{Operation: "TXN", Txn: &Txn{
+		Guards: []TxnGuard{{Key: "secret", Value: "s3cr3t"}},
+		Then:   []CommandPayload{{Operation: "GET", Key: "secret"}},
+	}})
+	require.NoError(t, resp.Error)
+	result := resp.Data.(*TxnResult)
+	assert.True(t, result.Succeeded)
+	assert.Equal(t, "s3cr3t", result.Results[0].Value)
+
+	t.Run("Snapshot Keeps Ciphertext", func(t *testing.T) {
+		snapshot, err := f.Snapshot()
+		require.NoError(t, err)
+		sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
+		require.NoError(t, snapshot.Persist(sink))
+		snapshot.Release()
+		assert.NotContains(t, sink.String(), "s3cr3t")
+
+		restoredKeys, err := encryption.NewKeyring(master)
+		require.NoError(t, err)
+		restored, restoredDB, _ := setupTestFSM(t)
+		defer func() { _ = restoredDB.Close() }()
+		require.NoError(t, restored.Restore(io.NopCloser(sink.Buffer)))
+
+		value, _, _ := read(restoredDB, restoredKeys, "secret")
+		assert.Equal(t, "s3cr3t", value)
+	})
+
+	t.Run("Reencrypt", func(t *testing.T) {
+		second, wrapped, err := leaderKeys.NewDataKey()
+		require.NoError(t, err)
+		require.NoError(t, apply(5, CommandPayload{Operation: "KEY", Key: second, Data: wrapped}).Error)
+
+		resealedSecret := sealed(second, "secret", "s3cr3t")
+		resealedPlain := sealed(second, "plain", "text")
+		staleIndex := uint64(1)
+		currentIndex := uint64(2)
+		plainIndex := uint64(3)
+		resealedSecret.CASIndex = &currentIndex
+		resealedPlain.CASIndex = &plainIndex
+		stale := sealed(second, "plain", "old")
+		stale.CASIndex = &staleIndex
+
+		resp := apply(6, CommandPayload{Operation: "REENCRYPT", Batch: []CommandPayload{resealedSecret, resealedPlain, stale}})
+		require.NoError(t, resp.Error)
+		assert.Equal(t, 2, resp.Data)
+
+		value, _, meta := read(db, keyring, "secret")
+		assert.Equal(t, "s3cr3t", value)
+		assert.Equal(t, second, meta.KeyID)
+		assert.Equal(t, uint64(2), meta.ModIndex, "re-encryption keeps the mod index")
+
+		value, raw, meta := read(db, keyring, "plain")
+		assert.Equal(t, "text", value)
+		assert.NotContains(t, string(raw), "text")
+		assert.Equal(t, second, meta.KeyID)
+	})
+
+	t.Run("No Keyring", func(t *testing.T) {
+		_, err := ReadValue(nil, nil, "secret", []byte("x"), KeyMeta{KeyID: first})
+		assert.Error(t, err)
+	})
+}
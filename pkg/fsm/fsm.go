@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
 	"github.com/subash-0044/beaver-vault/pkg/parser"
 	"github.com/subash-0044/beaver-vault/pkg/storage"
 
@@ -19,17 +22,47 @@ type CommandPayload struct {
 	Operation string
 	Key       string
 	Value     interface{}
+	// Data carries the raw bytes of a binary SET value, in place of Value.
+	// For a sealed SET it holds the ciphertext, and for KEY the wrapped key.
+	Data []byte `json:",omitempty"`
+	// KeyID names the data key a sealed SET value is encrypted with.
+	KeyID string `json:",omitempty"`
+	// Type is the type tag of the value sealed in Data.
+	Type string `json:",omitempty"`
+	// ExpiresAt is the Unix time (in seconds) at which a SET value expires,
+	// or zero if it never expires. The leader computes it once, so every
+	// replica expires the key at the same moment regardless of when it
+	// applies the entry.
+	ExpiresAt uint64 `json:",omitempty"`
+	// Mode restricts a SET to WriteModeCreate or WriteModeUpdate.
+	Mode string `json:",omitempty"`
+	// CASIndex, when set, makes a SET or DELETE apply only if the key's
+	// current mod index equals it. Zero means the key must not exist.
+	CASIndex *uint64 `json:",omitempty"`
+	// Txn holds the guards and operations of a TXN payload.
+	Txn *Txn `json:",omitempty"`
+	// Batch holds the SET and DELETE payloads of a BATCH payload, and the
+	// resealed values of a REENCRYPT payload.
+	Batch []CommandPayload `json:",omitempty"`
+	// Secret holds the arguments of a SECRET_* payload.
+	Secret *SecretOp `json:",omitempty"`
 }
 
 // ApplyResponse response from Apply raft
 type ApplyResponse struct {
 	Error error
 	Data  interface{}
+	// Index is the Raft log index of the entry that produced this response
+	Index uint64
 }
 
 // FSM implements raft.FSM using badgerDB
 type FSM struct {
-	parser *parser.Parser
+	db      *badger.DB
+	parser  *parser.Parser
+	watcher *Watcher
+	keyring *encryption.Keyring
+	onApply func(operation string, err error, took time.Duration)
 }
 
 // Apply log is invoked once a log entry is committed.
@@ -45,32 +78,35 @@ func (f FSM) Apply(log *raft.Log) interface{} {
 			return nil
 		}
 
+		// A restarted node replays its log from the last snapshot onto
+		// Badger, which already holds the effects of the entries it applied
+		applied, err := AppliedIndex(f.db)
+		if err != nil {
+			return &ApplyResponse{Error: err, Index: log.Index}
+		}
+		if applied > 0 && log.Index <= applied {
+			return &ApplyResponse{Index: log.Index}
+		}
+
 		op := strings.ToUpper(strings.TrimSpace(payload.Operation))
-		switch op {
-		case "SET":
-			err := f.parser.Put(payload.Key, payload.Value)
-			return &ApplyResponse{
-				Error: err,
-				Data:  payload.Value,
-			}
-		case "GET":
-			value, err := f.parser.Get(payload.Key)
-			var data interface{}
-			if err == nil && value != nil {
-				data = value.Data
-			} else {
-				data = make(map[string]interface{})
-			}
-			return &ApplyResponse{
-				Error: err,
-				Data:  data,
-			}
-		case "DELETE":
-			return &ApplyResponse{
-				Error: f.parser.Delete(payload.Key),
-				Data:  nil,
+		start := time.Now()
+		resp := f.applyCommand(op, payload, log.Index, entryTime(log))
+		if resp == nil || resp.Error != nil {
+			// A failed or unknown command changes nothing but must not be
+			// evaluated again on replay, against a later state
+			if err := f.db.Update(func(txn *badger.Txn) error {
+				return setAppliedIndex(txn, log.Index)
+			}); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "error recording applied index %d: %s\n", log.Index, err.Error())
 			}
 		}
+		if resp == nil {
+			break
+		}
+		if f.onApply != nil {
+			f.onApply(op, resp.Error, time.Since(start))
+		}
+		return resp
 	case raft.LogNoop, raft.LogAddPeerDeprecated, raft.LogRemovePeerDeprecated, raft.LogBarrier, raft.LogConfiguration:
 		// No operation for these log types
 		return nil
@@ -80,17 +116,150 @@ func (f FSM) Apply(log *raft.Log) interface{} {
 	return nil
 }
 
+// applyCommand applies a command payload committed at index, judging the
+// expiry of keys at now. It returns nil for an unknown operation.
+func (f FSM) applyCommand(op string, payload CommandPayload, index, now uint64) *ApplyResponse {
+	switch op {
+	case "SET":
+		err := f.applySet(payload, index, now)
+		if err == nil && (payload.Value != nil || payload.Data != nil) {
+			f.watcher.Publish(f.putEvent(payload, index))
+		}
+		return &ApplyResponse{
+			Error: err,
+			Data:  payload.Value,
+			Index: index,
+		}
+	case "GET":
+		value, err := f.parser.Get(payload.Key)
+		var data interface{}
+		if err == nil && value != nil {
+			data = value.Data
+		} else {
+			data = make(map[string]interface{})
+		}
+		return &ApplyResponse{
+			Error: err,
+			Data:  data,
+		}
+	case "DELETE":
+		err := f.applyDelete(payload, index, now)
+		if err == nil {
+			f.watcher.Publish(Event{Type: EventDelete, Key: payload.Key, Index: index})
+		}
+		return &ApplyResponse{
+			Error: err,
+			Data:  nil,
+			Index: index,
+		}
+	case "TXN":
+		result, err := f.applyTxn(payload.Txn, index, now)
+		if err == nil {
+			f.watcher.Publish(f.txnEvents(payload.Txn, result, index)...)
+		}
+		return &ApplyResponse{
+			Error: err,
+			Data:  result,
+			Index: index,
+		}
+	case "BATCH":
+		results, events, err := f.applyBatch(payload.Batch, index, now)
+		if err == nil {
+			f.watcher.Publish(events...)
+		}
+		return &ApplyResponse{
+			Error: err,
+			Data:  results,
+			Index: index,
+		}
+	case "SECRET_PUT", "SECRET_DELETE", "SECRET_UNDELETE", "SECRET_DESTROY", "SECRET_PURGE":
+		result, err := f.applySecret(op, payload, index)
+		return &ApplyResponse{
+			Error: err,
+			Data:  result,
+			Index: index,
+		}
+	case "KEY":
+		return &ApplyResponse{
+			Error: f.applyDataKey(payload, index),
+			Index: index,
+		}
+	case "REENCRYPT":
+		resealed, err := f.applyReencrypt(payload.Batch, index, now)
+		return &ApplyResponse{
+			Error: err,
+			Data:  resealed,
+			Index: index,
+		}
+	}
+	return nil
+}
+
+// entryTime returns the Unix time, in seconds, at which the leader appended
+// log. Conditions on keys judge expiry at this time rather than by the local
+// clock, so every replica, and a replay, reach the same outcome. Entries
+// without it, such as those applied in tests, use the local clock.
+func entryTime(log *raft.Log) uint64 {
+	if log.AppendedAt.IsZero() {
+		return uint64(time.Now().Unix())
+	}
+	return uint64(log.AppendedAt.Unix())
+}
+
+// AppliedIndex returns the index of the last log entry applied to the FSM
+// stored in db, or 0 if none was.
+func AppliedIndex(db *badger.DB) (uint64, error) {
+	var index uint64
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(appliedIndexKey))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			index, err = strconv.ParseUint(string(val), 10, 64)
+			return err
+		})
+	})
+	return index, err
+}
+
+// setAppliedIndex records index as the last applied entry. It is written
+// with the changes of the entry, in the same Badger transaction or write
+// batch, and is carried in snapshots like any other key. Raft numbers its
+// log from 1, so index 0 is not recorded.
+func setAppliedIndex(w interface{ Set(key, val []byte) error }, index uint64) error {
+	if index == 0 {
+		return nil
+	}
+	return w.Set([]byte(appliedIndexKey), []byte(strconv.FormatUint(index, 10)))
+}
+
+// update runs fn in a read-write transaction that also records index as
+// the last applied entry.
+func (f FSM) update(index uint64, fn func(txn *badger.Txn) error) error {
+	return f.db.Update(func(txn *badger.Txn) error {
+		if err := fn(txn); err != nil {
+			return err
+		}
+		return setAppliedIndex(txn, index)
+	})
+}
+
 // Snapshot will be called during make snapshot.
 // Snapshot is used to support log compaction.
-// No need to call snapshot since it already persisted in disk (using BadgerDB) when raft calling Apply function.
+// It pins a read-only BadgerDB transaction, so the snapshot sees the keyspace
+// as of this call even while Apply keeps writing.
 func (f FSM) Snapshot() (raft.FSMSnapshot, error) {
-	return newSnapshotNoop()
+	return newSnapshot(f.db), nil
 }
 
 // Restore is used to restore an FSM from a Snapshot. It is not called
 // concurrently with any other command. The FSM must discard all previous
 // state.
-// Restore will update all data in BadgerDB
+// Restore drops all data in BadgerDB and replaces it with the snapshot content.
 func (f FSM) Restore(rClose io.ReadCloser) error {
 	defer func() {
 		if err := rClose.Close(); err != nil {
@@ -101,7 +270,23 @@ func (f FSM) Restore(rClose io.ReadCloser) error {
 	_, _ = fmt.Fprintf(os.Stdout, "[START RESTORE] read all message from snapshot\n")
 	var totalRestored int
 
+	// Subscribers cannot be told what the snapshot changed
+	f.watcher.Reset()
+
+	if err := f.db.DropAll(); err != nil {
+		_, _ = fmt.Fprintf(os.Stdout, "[END RESTORE] error dropping existing data %s\n", err.Error())
+		return err
+	}
+
 	decoder := json.NewDecoder(rClose)
+	decoder.UseNumber()
+
+	// read opening bracket
+	if _, err := decoder.Token(); err != nil {
+		_, _ = fmt.Fprintf(os.Stdout, "[END RESTORE] error %s\n", err.Error())
+		return err
+	}
+
 	for decoder.More() {
 		var data = &CommandPayload{}
 		err := decoder.Decode(data)
@@ -110,7 +295,7 @@ func (f FSM) Restore(rClose io.ReadCloser) error {
 			return err
 		}
 
-		if err := f.parser.Put(data.Key, data.Value); err != nil {
+		if err := f.restoreValue(data); err != nil {
 			_, _ = fmt.Fprintf(os.Stdout, "[END RESTORE] error persist data %s\n", err.Error())
 			return err
 		}
@@ -119,20 +304,73 @@ func (f FSM) Restore(rClose io.ReadCloser) error {
 	}
 
 	// read closing bracket
-	_, err := decoder.Token()
-	if err != nil {
+	if _, err := decoder.Token(); err != nil {
 		_, _ = fmt.Fprintf(os.Stdout, "[END RESTORE] error %s\n", err.Error())
 		return err
 	}
 
+	// Changes after the snapshot are published from here on
+	applied, err := AppliedIndex(f.db)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stdout, "[END RESTORE] error reading applied index %s\n", err.Error())
+		return err
+	}
+	f.watcher.resume(applied)
+
 	_, _ = fmt.Fprintf(os.Stdout, "[END RESTORE] success restore %d messages in snapshot\n", totalRestored)
 	return nil
 }
 
+// restoreValue writes a snapshot entry back. Binary values are written as
+// is; JSON values go through the parser.
+func (f FSM) restoreValue(data *CommandPayload) error {
+	if data.Data == nil {
+		return f.parser.PutWithExpiry(data.Key, data.Value, data.ExpiresAt)
+	}
+	return f.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(expiringEntry([]byte(data.Key), data.Data, data.ExpiresAt))
+	})
+}
+
 // New creates a new raft.FSM implementation using badgerDB
 func New(badgerDB *badger.DB) raft.FSM {
+	return NewWithWatcher(badgerDB, nil)
+}
+
+// NewWithWatcher creates a raft.FSM like New that publishes every committed
+// change to watcher. A nil watcher disables publishing.
+func NewWithWatcher(badgerDB *badger.DB, watcher *Watcher) raft.FSM {
+	return NewWithOptions(badgerDB, Options{Watcher: watcher})
+}
+
+// Options configures an FSM created by NewWithOptions.
+type Options struct {
+	// Watcher, if set, receives every committed change.
+	Watcher *Watcher
+	// Keyring decrypts sealed values for transaction guards, transaction
+	// reads and watch events. Without it those values cannot be read.
+	Keyring *encryption.Keyring
+	// OnApply, if set, is called after each command with its operation, its
+	// error and how long it took to apply.
+	OnApply func(operation string, err error, took time.Duration)
+}
+
+// NewWithOptions creates a raft.FSM using badgerDB configured by opts.
+func NewWithOptions(badgerDB *badger.DB, opts Options) raft.FSM {
+	// A restarted node publishes every change after those already in
+	// Badger, so watches can resume from there
+	if applied, err := AppliedIndex(badgerDB); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error reading applied index %s\n", err.Error())
+	} else {
+		opts.Watcher.resume(applied)
+	}
+
 	store := &storage.BadgerStore{DB: badgerDB}
 	return &FSM{
-		parser: parser.NewParser(store),
+		db:      badgerDB,
+		parser:  parser.NewParser(store),
+		watcher: opts.Watcher,
+		keyring: opts.Keyring,
+		onApply: opts.OnApply,
 	}
 }
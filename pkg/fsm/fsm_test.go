@@ -3,7 +3,9 @@ package fsm
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/hashicorp/raft"
@@ -144,11 +146,23 @@ func TestFSM_Snapshot(t *testing.T) {
 	fsm, db, _ := setupTestFSM(t)
 	defer func() { _ = db.Close() }()
 
+	values := map[string]interface{}{
+		"service/foo/port": float64(8080),
+		"service/foo/name": "foo",
+		"service/bar":      map[string]interface{}{"enabled": true},
+	}
+	for key, value := range values {
+		require.NoError(t, fsm.parser.Put(key, value))
+	}
+
 	// Take snapshot
 	snapshot, err := fsm.Snapshot()
 	assert.NoError(t, err)
 	assert.NotNil(t, snapshot)
 
+	// Writes after the snapshot must not leak into it
+	require.NoError(t, fsm.parser.Put("late-key", "late-value"))
+
 	// Test snapshot persistence
 	sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
 	err = snapshot.Persist(sink)
@@ -156,4 +170,434 @@ func TestFSM_Snapshot(t *testing.T) {
 
 	// Test snapshot release
 	snapshot.Release()
+
+	// Restore into a second FSM that already holds unrelated data
+	restored, restoredDB, _ := setupTestFSM(t)
+	defer func() { _ = restoredDB.Close() }()
+	require.NoError(t, restored.parser.Put("stale-key", "stale-value"))
+
+	err = restored.Restore(io.NopCloser(sink.Buffer))
+	require.NoError(t, err)
+
+	for key, want := range values {
+		got, err := restored.parser.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got.Data, key)
+	}
+
+	for _, key := range []string{"late-key", "stale-key"} {
+		got, err := restored.parser.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{}, got.Data, key)
+	}
+}
+
+func TestFSM_SnapshotEmpty(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	snapshot, err := fsm.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Release()
+
+	sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
+	require.NoError(t, snapshot.Persist(sink))
+	assert.Equal(t, "[]", sink.String())
+
+	assert.NoError(t, fsm.Restore(io.NopCloser(sink.Buffer)))
+}
+
+func TestFSM_ApplyExpiry(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	apply := func(payload CommandPayload) *ApplyResponse {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		result := fsm.Apply(&raft.Log{Type: raft.LogCommand, Data: data})
+		response, ok := result.(*ApplyResponse)
+		require.True(t, ok)
+		return response
+	}
+
+	now := uint64(time.Now().Unix())
+	require.NoError(t, apply(CommandPayload{Operation: "SET", Key: "expired", Value: "v", ExpiresAt: now - 10}).Error)
+	require.NoError(t, apply(CommandPayload{Operation: "SET", Key: "live", Value: "v", ExpiresAt: now + 3600}).Error)
+
+	// The expiry comes from the log entry, not from when it was applied
+	assert.Equal(t, map[string]interface{}{}, apply(CommandPayload{Operation: "GET", Key: "expired"}).Data)
+	assert.Equal(t, "v", apply(CommandPayload{Operation: "GET", Key: "live"}).Data)
+
+	// Snapshots carry the expiry so restored replicas expire at the same time
+	snapshot, err := fsm.Snapshot()
+	require.NoError(t, err)
+	defer snapshot.Release()
+
+	sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
+	require.NoError(t, snapshot.Persist(sink))
+
+	var payloads []CommandPayload
+	require.NoError(t, json.Unmarshal(sink.Bytes(), &payloads))
+	expiries := make(map[string]uint64)
+	for _, payload := range payloads {
+		expiries[payload.Key] = payload.ExpiresAt
+	}
+	assert.Equal(t, map[string]uint64{"live": now + 3600, "_beaver/meta/live": now + 3600}, expiries)
+}
+
+func TestFSM_ApplyExpiryAtEntryTime(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	// The key expired a minute ago by the local clock, but the entries below
+	// were appended by the leader before that
+	expiresAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	before := expiresAt.Add(-30 * time.Second)
+	after := expiresAt.Add(time.Second)
+
+	apply := func(index uint64, appendedAt time.Time, payload CommandPayload) *ApplyResponse {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		result := fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data, AppendedAt: appendedAt})
+		return result.(*ApplyResponse)
+	}
+	cas := func(index uint64) *uint64 { return &index }
+	exists := true
+
+	set := CommandPayload{Operation: "SET", Key: "k", Value: "v", ExpiresAt: uint64(expiresAt.Unix())}
+	require.NoError(t, apply(1, before, set).Error)
+
+	var conflict *ConflictError
+	err := apply(2, before, CommandPayload{Operation: "SET", Key: "k", Value: "w", Mode: WriteModeCreate}).Error
+	assert.ErrorAs(t, err, &conflict, "the key still existed when the entry was appended")
+
+	resp := apply(3, before, CommandPayload{Operation: "TXN", Txn: &Txn{
+		Guards: []TxnGuard{{Key: "k", Exists: &exists, ModIndex: cas(1), Value: "v"}},
+		Then:   []CommandPayload{{Operation: "GET", Key: "k"}},
+	}})
+	require.NoError(t, resp.Error)
+	assert.True(t, resp.Data.(*TxnResult).Succeeded)
+	assert.Equal(t, "v", resp.Data.(*TxnResult).Results[0].Value)
+
+	resp = apply(4, before, CommandPayload{Operation: "BATCH", Batch: []CommandPayload{
+		{Operation: "SET", Key: "k", Value: "x", CASIndex: cas(1), ExpiresAt: uint64(expiresAt.Unix())},
+	}})
+	require.NoError(t, resp.Error)
+	assert.Empty(t, resp.Data.([]BatchOpResult)[0].Error)
+
+	// Once the entry time is past the expiry the key is absent, and a new
+	// one gets a new create index
+	resp = apply(5, after, CommandPayload{Operation: "SET", Key: "k", Value: "y", Mode: WriteModeCreate})
+	require.NoError(t, resp.Error)
+	require.NoError(t, db.View(func(txn *badger.Txn) error {
+		meta, err := ReadMeta(txn, "k")
+		assert.Equal(t, KeyMeta{CreateIndex: 5, ModIndex: 5}, meta)
+		return err
+	}))
+}
+
+func TestFSM_ApplyConditional(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	apply := func(index uint64, payload CommandPayload) *ApplyResponse {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		result := fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data})
+		response, ok := result.(*ApplyResponse)
+		require.True(t, ok)
+		return response
+	}
+	meta := func(key string) KeyMeta {
+		var m KeyMeta
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			var err error
+			m, err = ReadMeta(txn, key)
+			return err
+		}))
+		return m
+	}
+	cas := func(index uint64) *uint64 { return &index }
+
+	// Create-only write on a fresh key records both indexes
+	require.NoError(t, apply(10, CommandPayload{Operation: "SET", Key: "k", Value: "v1", Mode: WriteModeCreate}).Error)
+	assert.Equal(t, KeyMeta{CreateIndex: 10, ModIndex: 10}, meta("k"))
+
+	// Create-only on an existing key conflicts
+	var conflict *ConflictError
+	err := apply(11, CommandPayload{Operation: "SET", Key: "k", Value: "v2", Mode: WriteModeCreate}).Error
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, uint64(10), conflict.ModIndex)
+
+	// CAS with a stale index conflicts, with the current index succeeds
+	err = apply(12, CommandPayload{Operation: "SET", Key: "k", Value: "v2", CASIndex: cas(9)}).Error
+	require.ErrorAs(t, err, &conflict)
+	require.NoError(t, apply(13, CommandPayload{Operation: "SET", Key: "k", Value: "v2", CASIndex: cas(10)}).Error)
+	assert.Equal(t, KeyMeta{CreateIndex: 10, ModIndex: 13}, meta("k"))
+	assert.Equal(t, "v2", apply(14, CommandPayload{Operation: "GET", Key: "k"}).Data)
+
+	// Update-only on a missing key conflicts
+	err = apply(15, CommandPayload{Operation: "SET", Key: "missing", Value: "v", Mode: WriteModeUpdate}).Error
+	require.ErrorAs(t, err, &conflict)
+
+	// CAS delete
+	err = apply(16, CommandPayload{Operation: "DELETE", Key: "k", CASIndex: cas(10)}).Error
+	require.ErrorAs(t, err, &conflict)
+	require.NoError(t, apply(17, CommandPayload{Operation: "DELETE", Key: "k", CASIndex: cas(13)}).Error)
+	assert.Equal(t, KeyMeta{}, meta("k"))
+}
+
+func TestFSM_ApplyTxn(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	apply := func(index uint64, txn *Txn) *ApplyResponse {
+		data, err := json.Marshal(CommandPayload{Operation: "TXN", Txn: txn})
+		require.NoError(t, err)
+		result := fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data})
+		response, ok := result.(*ApplyResponse)
+		require.True(t, ok)
+		return response
+	}
+	get := func(key string) interface{} {
+		value, err := fsm.parser.Get(key)
+		require.NoError(t, err)
+		if value == nil {
+			return nil
+		}
+		return value.Data
+	}
+	absent, modIndex := false, uint64(0)
+
+	// Create the config and its version pointer together
+	response := apply(5, &Txn{
+		Guards: []TxnGuard{{Key: "config/version", Exists: &absent}},
+		Then: []CommandPayload{
+			{Operation: "SET", Key: "config/v1", Value: map[string]interface{}{"replicas": 3}},
+			{Operation: "SET", Key: "config/version", Value: "v1"},
+		},
+	})
+	require.NoError(t, response.Error)
+	result := response.Data.(*TxnResult)
+	assert.True(t, result.Succeeded)
+	require.Len(t, result.Results, 2)
+	assert.Equal(t, uint64(5), result.Results[1].ModIndex)
+	assert.Equal(t, "v1", get("config/version"))
+
+	// Failed guards apply the else branch
+	modIndex = 4
+	response = apply(6, &Txn{
+		Guards: []TxnGuard{{Key: "config/version", Value: "v1"}, {Key: "config/version", ModIndex: &modIndex}},
+		Then:   []CommandPayload{{Operation: "SET", Key: "config/version", Value: "v2"}},
+		Else:   []CommandPayload{{Operation: "GET", Key: "config/v1"}},
+	})
+	require.NoError(t, response.Error)
+	result = response.Data.(*TxnResult)
+	assert.False(t, result.Succeeded)
+	require.Len(t, result.Results, 1)
+	assert.Equal(t, map[string]interface{}{"replicas": float64(3)}, result.Results[0].Value)
+	assert.Equal(t, uint64(5), result.Results[0].ModIndex)
+	assert.Equal(t, "v1", get("config/version"))
+
+	// Value and mod index guards that hold apply the then branch
+	modIndex = 5
+	response = apply(7, &Txn{
+		Guards: []TxnGuard{{Key: "config/v1", Value: map[string]interface{}{"replicas": 3}}, {Key: "config/version", ModIndex: &modIndex}},
+		Then: []CommandPayload{
+			{Operation: "SET", Key: "config/v2", Value: map[string]interface{}{"replicas": 5}},
+			{Operation: "SET", Key: "config/version", Value: "v2"},
+			{Operation: "DELETE", Key: "config/v1"},
+		},
+	})
+	require.NoError(t, response.Error)
+	assert.True(t, response.Data.(*TxnResult).Succeeded)
+	assert.Equal(t, "v2", get("config/version"))
+	assert.Empty(t, get("config/v1"))
+
+	// A failing operation rolls back the whole transaction
+	var conflict *ConflictError
+	response = apply(8, &Txn{
+		Then: []CommandPayload{
+			{Operation: "SET", Key: "config/version", Value: "v3"},
+			{Operation: "SET", Key: "config/v2", Value: "x", Mode: WriteModeCreate},
+		},
+	})
+	require.ErrorAs(t, response.Error, &conflict)
+	assert.Equal(t, "v2", get("config/version"))
+
+	response = apply(9, &Txn{Then: []CommandPayload{{Operation: "TXN", Key: "nested"}}})
+	assert.Error(t, response.Error)
+}
+
+func TestFSM_ApplyBatch(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	apply := func(index uint64, ops ...CommandPayload) *ApplyResponse {
+		data, err := json.Marshal(CommandPayload{Operation: "BATCH", Batch: ops})
+		require.NoError(t, err)
+		result := fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data})
+		response, ok := result.(*ApplyResponse)
+		require.True(t, ok)
+		return response
+	}
+	meta := func(key string) KeyMeta {
+		var m KeyMeta
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			var err error
+			m, err = ReadMeta(txn, key)
+			return err
+		}))
+		return m
+	}
+
+	response := apply(3,
+		CommandPayload{Operation: "SET", Key: "a", Value: "1"},
+		CommandPayload{Operation: "SET", Key: "b", Value: map[string]interface{}{"n": 2}},
+		CommandPayload{Operation: "GET", Key: "a"},
+	)
+	require.NoError(t, response.Error)
+	results := response.Data.([]BatchOpResult)
+	require.Len(t, results, 3)
+	assert.Equal(t, BatchOpResult{Operation: "SET", Key: "a", ModIndex: 3}, results[0])
+	assert.Equal(t, uint64(3), results[1].ModIndex)
+	assert.NotEmpty(t, results[2].Error)
+	assert.Equal(t, KeyMeta{CreateIndex: 3, ModIndex: 3}, meta("a"))
+
+	// Existing keys keep their create index; keys deleted and set again get a new one
+	response = apply(4,
+		CommandPayload{Operation: "SET", Key: "a", Value: "2"},
+		CommandPayload{Operation: "DELETE", Key: "b"},
+		CommandPayload{Operation: "SET", Key: "b", Value: "3"},
+		CommandPayload{Operation: "DELETE", Key: "c"},
+	)
+	require.NoError(t, response.Error)
+	for _, result := range response.Data.([]BatchOpResult) {
+		assert.Empty(t, result.Error)
+	}
+	assert.Equal(t, KeyMeta{CreateIndex: 3, ModIndex: 4}, meta("a"))
+	assert.Equal(t, KeyMeta{CreateIndex: 4, ModIndex: 4}, meta("b"))
+
+	value, err := fsm.parser.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "3", value.Data)
+
+	// Conditions are checked against the state left by earlier operations
+	stale, current := uint64(3), uint64(4)
+	response = apply(5,
+		CommandPayload{Operation: "SET", Key: "a", Value: "x", CASIndex: &stale},
+		CommandPayload{Operation: "SET", Key: "a", Value: "y", CASIndex: &current},
+		CommandPayload{Operation: "SET", Key: "d", Value: "z", Mode: WriteModeCreate},
+		CommandPayload{Operation: "SET", Key: "d", Value: "z", Mode: WriteModeCreate},
+		CommandPayload{Operation: "DELETE", Key: "b", CASIndex: &stale},
+	)
+	require.NoError(t, response.Error)
+	results = response.Data.([]BatchOpResult)
+	var conflict *ConflictError
+	assert.ErrorAs(t, results[0].Err, &conflict)
+	assert.NoError(t, results[1].Err)
+	assert.NoError(t, results[2].Err)
+	assert.ErrorAs(t, results[3].Err, &conflict)
+	assert.ErrorAs(t, results[4].Err, &conflict)
+	assert.Equal(t, KeyMeta{CreateIndex: 3, ModIndex: 5}, meta("a"))
+	assert.Equal(t, KeyMeta{CreateIndex: 4, ModIndex: 4}, meta("b"))
+}
+
+func TestFSM_BinaryValues(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	// Bytes that are not valid JSON, and bytes that happen to be
+	blob := []byte{0x00, 0xff, '{', 0x10}
+	jsonLike := []byte(`{"b": 1,  "a": 2}`)
+
+	apply := func(index uint64, payload CommandPayload) *ApplyResponse {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		return fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data}).(*ApplyResponse)
+	}
+	read := func(db *badger.DB, key string) (interface{}, KeyMeta) {
+		var value interface{}
+		var meta KeyMeta
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get([]byte(key))
+			if err != nil {
+				return err
+			}
+			if meta, err = ReadMeta(txn, key); err != nil {
+				return err
+			}
+			return item.Value(func(val []byte) error {
+				value, err = DecodeValue(val, meta)
+				return err
+			})
+		}))
+		return value, meta
+	}
+
+	require.NoError(t, apply(1, CommandPayload{Operation: "SET", Key: "blob", Data: blob}).Error)
+	require.NoError(t, apply(2, CommandPayload{Operation: "SET", Key: "json-like", Data: jsonLike}).Error)
+	require.NoError(t, apply(3, CommandPayload{Operation: "SET", Key: "plain", Value: "text"}).Error)
+
+	value, meta := read(db, "blob")
+	assert.Equal(t, blob, value)
+	assert.Equal(t, ValueTypeBinary, meta.Type)
+
+	// Snapshots keep binary values byte for byte
+	snapshot, err := fsm.Snapshot()
+	require.NoError(t, err)
+	sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
+	require.NoError(t, snapshot.Persist(sink))
+	snapshot.Release()
+
+	restored, restoredDB, _ := setupTestFSM(t)
+	defer func() { _ = restoredDB.Close() }()
+	require.NoError(t, restored.Restore(io.NopCloser(sink.Buffer)))
+
+	value, meta = read(restoredDB, "blob")
+	assert.Equal(t, blob, value)
+	assert.Equal(t, ValueTypeBinary, meta.Type)
+	value, _ = read(restoredDB, "json-like")
+	assert.Equal(t, jsonLike, value)
+	value, meta = read(restoredDB, "plain")
+	assert.Equal(t, "text", value)
+	assert.Empty(t, meta.Type)
+
+	// Overwriting with JSON clears the tag
+	require.NoError(t, apply(4, CommandPayload{Operation: "SET", Key: "blob", Value: 1}).Error)
+	value, meta = read(db, "blob")
+	assert.Equal(t, float64(1), value)
+	assert.Empty(t, meta.Type)
+}
+
+func TestFSM_OnApply(t *testing.T) {
+	_, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	type call struct {
+		operation string
+		failed    bool
+	}
+	var calls []call
+	fsm := NewWithOptions(db, Options{OnApply: func(operation string, err error, took time.Duration) {
+		assert.GreaterOrEqual(t, took, time.Duration(0))
+		calls = append(calls, call{operation, err != nil})
+	}})
+
+	apply := func(index uint64, payload CommandPayload) {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data})
+	}
+	apply(1, CommandPayload{Operation: "set", Key: "k", Value: "v"})
+	apply(2, CommandPayload{Operation: "SET", Key: "k", Value: "w", Mode: WriteModeCreate})
+	apply(3, CommandPayload{Operation: "UNKNOWN", Key: "k"})
+	fsm.Apply(&raft.Log{Type: raft.LogNoop, Index: 4})
+
+	assert.Equal(t, []call{{"SET", false}, {"SET", true}}, calls)
+
+	// Unknown commands are not applied again on replay either
+	applied, err := AppliedIndex(db)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), applied)
 }
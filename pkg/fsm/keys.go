@@ -0,0 +1,91 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReservedKeyPrefix marks the part of the keyspace used by the cluster itself.
+// Keys under it are replicated like any other key, but clients cannot
+// read or write them through the KV API.
+const ReservedKeyPrefix = "_beaver/"
+
+// ReservedKeyEnd is the first key after every key under ReservedKeyPrefix,
+// where scans resume to skip the reserved keyspace.
+const ReservedKeyEnd = "_beaver0"
+
+// nodeKeyPrefix holds per-node metadata published through Raft.
+const nodeKeyPrefix = ReservedKeyPrefix + "nodes/"
+
+// metaKeyPrefix holds the KeyMeta of every key, stored next to its value.
+const metaKeyPrefix = ReservedKeyPrefix + "meta/"
+
+// appliedIndexKey holds the index of the last log entry applied to the FSM.
+const appliedIndexKey = ReservedKeyPrefix + "fsm/applied"
+
+// encryptionKeyPrefix holds the data keys, wrapped by the master key.
+const encryptionKeyPrefix = ReservedKeyPrefix + "encryption/keys/"
+
+// activeKeyKey holds the id of the data key new values are sealed with.
+const activeKeyKey = ReservedKeyPrefix + "encryption/active"
+
+// secretMetaPrefix holds the SecretMetadata of every secret.
+const secretMetaPrefix = ReservedKeyPrefix + "secret/meta/"
+
+// secretDataPrefix holds the versions of every secret, each under its own
+// key next to its KeyMeta.
+const secretDataPrefix = ReservedKeyPrefix + "secret/data/"
+
+// Keys of the authentication data. Tokens are stored under the SHA-256 hash
+// of their secret, so the secret itself is never replicated.
+const (
+	AuthTokenPrefix    = ReservedKeyPrefix + "auth/tokens/"
+	AuthPolicyPrefix   = ReservedKeyPrefix + "auth/policies/"
+	AuthInitializedKey = ReservedKeyPrefix + "auth/initialized"
+)
+
+// IsReservedKey reports whether key belongs to the reserved keyspace.
+func IsReservedKey(key string) bool {
+	return strings.HasPrefix(key, ReservedKeyPrefix)
+}
+
+// NodeHTTPAddressKey returns the key under which a node's HTTP API address
+// is stored.
+func NodeHTTPAddressKey(nodeID string) string {
+	return nodeKeyPrefix + nodeID + "/http"
+}
+
+func metaKey(key string) []byte {
+	return []byte(metaKeyPrefix + key)
+}
+
+func secretMetaKey(path string) []byte {
+	return []byte(secretMetaPrefix + path)
+}
+
+// secretVersionKey returns the key of one version of a secret. Versions are
+// zero padded so they sort in order.
+func secretVersionKey(path string, version uint64) string {
+	return fmt.Sprintf("%s%s/%020d", secretDataPrefix, path, version)
+}
+
+// IsSecretVersionKey reports whether key holds a version of a secret.
+func IsSecretVersionKey(key string) bool {
+	return strings.HasPrefix(key, secretDataPrefix)
+}
+
+// SecretSealingName returns the name every version of the secret at path is
+// sealed under.
+func SecretSealingName(path string) string {
+	return secretDataPrefix + path
+}
+
+// SealingName returns the name a value stored under key is sealed under:
+// the key itself, or the secret a version belongs to, since the version is
+// only assigned when the value is applied.
+func SealingName(key string) string {
+	if IsSecretVersionKey(key) {
+		return key[:strings.LastIndex(key, "/")]
+	}
+	return key
+}
@@ -0,0 +1,243 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Write modes for CommandPayload.Mode
+const (
+	// WriteModeCreate only writes keys that do not exist yet.
+	WriteModeCreate = "create"
+	// WriteModeUpdate only writes keys that already exist.
+	WriteModeUpdate = "update"
+)
+
+// ValueTypeBinary tags values stored as raw bytes. Values without a type
+// tag are JSON.
+const ValueTypeBinary = "binary"
+
+// KeyMeta records the Raft log indexes that created and last modified a key,
+// the type of its value and, for sealed values, the data key id.
+type KeyMeta struct {
+	CreateIndex uint64 `json:"create_index"`
+	ModIndex    uint64 `json:"mod_index"`
+	Type        string `json:"type,omitempty"`
+	KeyID       string `json:"key_id,omitempty"`
+}
+
+// ConflictError is returned in ApplyResponse.Error when a conditional write
+// does not match the current state of the key.
+type ConflictError struct {
+	Key    string
+	Reason string
+	// ModIndex is the current modification index of the key, or 0 if it
+	// does not exist.
+	ModIndex uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict on key %s: %s", e.Key, e.Reason)
+}
+
+// ReadMeta returns the index metadata of key within txn. A key that does not
+// exist has zero indexes.
+func ReadMeta(txn *badger.Txn, key string) (KeyMeta, error) {
+	item, err := txn.Get(metaKey(key))
+	return decodeMeta(item, err)
+}
+
+// readMetaAt returns the index metadata of key like ReadMeta, judging expiry
+// at now like getAt.
+func readMetaAt(txn *badger.Txn, key string, now uint64) (KeyMeta, error) {
+	item, err := getAt(txn, metaKey(key), now)
+	return decodeMeta(item, err)
+}
+
+func decodeMeta(item *badger.Item, err error) (KeyMeta, error) {
+	var meta KeyMeta
+	if err == badger.ErrKeyNotFound {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &meta)
+	})
+	return meta, err
+}
+
+// getAt returns the item of key as of now, the Unix time at which the leader
+// appended the entry being applied, or badger.ErrKeyNotFound if the key does
+// not exist or has expired by then. txn.Get judges expiry by the local clock
+// at apply time instead, which differs between replicas and between the
+// first apply of an entry and a replay.
+func getAt(txn *badger.Txn, key []byte, now uint64) (*badger.Item, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	// Without AllVersions the iterator hides what the local clock expired
+	opts.AllVersions = true
+	opts.Prefix = key
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	// The newest version of the key comes first
+	it.Seek(key)
+	if !it.Valid() || !bytes.Equal(it.Item().Key(), key) {
+		return nil, badger.ErrKeyNotFound
+	}
+	item := it.Item()
+	expiresAt := item.ExpiresAt()
+	// Deletes carry no expiry, so a hidden version without one is a delete
+	if item.IsDeletedOrExpired() && expiresAt == 0 {
+		return nil, badger.ErrKeyNotFound
+	}
+	if expiresAt != 0 && expiresAt <= now {
+		return nil, badger.ErrKeyNotFound
+	}
+	return item, nil
+}
+
+// DecodeValue converts a stored value into a Go value: the raw bytes for
+// binary values, the decoded JSON otherwise.
+func DecodeValue(raw []byte, meta KeyMeta) (interface{}, error) {
+	if meta.Type == ValueTypeBinary {
+		return append([]byte{}, raw...), nil
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// EncodeValue returns the bytes to store for a SET payload and its type tag.
+// A sealed payload keeps the type tag of the value it encrypts.
+func EncodeValue(payload CommandPayload) ([]byte, string, error) {
+	if payload.KeyID != "" {
+		return payload.Data, payload.Type, nil
+	}
+	if payload.Data != nil {
+		return payload.Data, ValueTypeBinary, nil
+	}
+	data, err := json.Marshal(payload.Value)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return data, "", nil
+}
+
+// checkCondition verifies the write mode and CAS index of payload against
+// the state of the key at now.
+func checkCondition(txn *badger.Txn, payload CommandPayload, now uint64) (KeyMeta, bool, error) {
+	_, err := getAt(txn, []byte(payload.Key), now)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return KeyMeta{}, false, err
+	}
+	exists := err == nil
+
+	var meta KeyMeta
+	if exists {
+		if meta, err = readMetaAt(txn, payload.Key, now); err != nil {
+			return meta, exists, err
+		}
+	}
+	return meta, exists, conditionError(payload, meta, exists)
+}
+
+// conditionError returns a *ConflictError if payload's write mode or CAS
+// index does not match a key in the given state.
+func conditionError(payload CommandPayload, meta KeyMeta, exists bool) error {
+	conflict := func(reason string) error {
+		return &ConflictError{Key: payload.Key, Reason: reason, ModIndex: meta.ModIndex}
+	}
+	switch {
+	case payload.Mode == WriteModeCreate && exists:
+		return conflict("key already exists")
+	case payload.Mode == WriteModeUpdate && !exists:
+		return conflict("key does not exist")
+	case payload.CASIndex != nil && *payload.CASIndex != meta.ModIndex:
+		return conflict(fmt.Sprintf("expected mod index %d, found %d", *payload.CASIndex, meta.ModIndex))
+	}
+	return nil
+}
+
+// applySet stores payload.Value at index, honouring its write conditions at
+// now.
+func (f FSM) applySet(payload CommandPayload, index, now uint64) error {
+	return f.update(index, func(txn *badger.Txn) error {
+		return setInTxn(txn, payload, index, now)
+	})
+}
+
+// applyDelete removes payload.Key and its metadata at index, honouring its
+// CAS index at now.
+func (f FSM) applyDelete(payload CommandPayload, index, now uint64) error {
+	return f.update(index, func(txn *badger.Txn) error {
+		return deleteInTxn(txn, payload, now)
+	})
+}
+
+// setInTxn writes a SET payload and its metadata within txn.
+func setInTxn(txn *badger.Txn, payload CommandPayload, index, now uint64) error {
+	if len(payload.Key) == 0 {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if payload.Value == nil && payload.Data == nil {
+		return nil
+	}
+
+	data, valueType, err := EncodeValue(payload)
+	if err != nil {
+		return err
+	}
+
+	meta, exists, err := checkCondition(txn, payload, now)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		meta.CreateIndex = index
+	}
+	meta.ModIndex = index
+	meta.Type = valueType
+	meta.KeyID = payload.KeyID
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// The metadata expires with the value it describes
+	if err := txn.SetEntry(expiringEntry([]byte(payload.Key), data, payload.ExpiresAt)); err != nil {
+		return err
+	}
+	return txn.SetEntry(expiringEntry(metaKey(payload.Key), metaData, payload.ExpiresAt))
+}
+
+// deleteInTxn removes a DELETE payload's key and its metadata within txn.
+func deleteInTxn(txn *badger.Txn, payload CommandPayload, now uint64) error {
+	if len(payload.Key) == 0 {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if _, _, err := checkCondition(txn, payload, now); err != nil {
+		return err
+	}
+	if err := txn.Delete([]byte(payload.Key)); err != nil {
+		return err
+	}
+	return txn.Delete(metaKey(payload.Key))
+}
+
+func expiringEntry(key, value []byte, expiresAt uint64) *badger.Entry {
+	entry := badger.NewEntry(key, value)
+	entry.ExpiresAt = expiresAt
+	return entry
+}
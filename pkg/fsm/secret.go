@@ -0,0 +1,261 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
+)
+
+// DefaultSecretVersions is the number of versions kept per secret when the
+// write does not say otherwise.
+const DefaultSecretVersions = 10
+
+// ErrSecretNotFound is returned for operations on a secret or version that
+// does not exist.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretOp carries the arguments of the SECRET_* payloads. The secret path is
+// the payload Key, and a SECRET_PUT carries its value like a SET.
+type SecretOp struct {
+	// Versions lists the versions a delete, undelete or destroy applies to.
+	// An empty list selects the current version.
+	Versions []uint64 `json:",omitempty"`
+	// MaxVersions is the number of versions a SECRET_PUT keeps; older ones
+	// are destroyed. Zero keeps the secret's current setting.
+	MaxVersions int `json:",omitempty"`
+}
+
+// SecretMetadata describes a secret and each version it still keeps.
+// Versions are stored under their own keys; the metadata only indexes them.
+type SecretMetadata struct {
+	CurrentVersion uint64 `json:"current_version"`
+	OldestVersion  uint64 `json:"oldest_version"`
+	MaxVersions    int    `json:"max_versions"`
+	CreateIndex    uint64 `json:"create_index"`
+	ModIndex       uint64 `json:"mod_index"`
+	// Versions maps each kept version number to its state.
+	Versions map[uint64]*SecretVersion `json:"versions"`
+}
+
+// SecretVersion is the state of one version of a secret.
+type SecretVersion struct {
+	CreateIndex uint64 `json:"create_index"`
+	// DeleteIndex is the index of the soft delete of the version, or 0. A
+	// deleted version keeps its value until it is destroyed.
+	DeleteIndex uint64 `json:"delete_index,omitempty"`
+	// Destroyed versions have had their value removed for good.
+	Destroyed bool `json:"destroyed,omitempty"`
+}
+
+// ReadSecretMetadata returns the metadata of the secret at path, or nil if
+// it does not exist.
+func ReadSecretMetadata(txn *badger.Txn, path string) (*SecretMetadata, error) {
+	item, err := txn.Get(secretMetaKey(path))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &SecretMetadata{}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, meta)
+	})
+	return meta, err
+}
+
+// ReadSecretVersion returns the decrypted and decoded value of a version of
+// the secret at path, or nil if the version has no value.
+func ReadSecretVersion(txn *badger.Txn, keyring *encryption.Keyring, path string, version uint64) (interface{}, error) {
+	key := secretVersionKey(path, version)
+	item, err := txn.Get([]byte(key))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := ReadMeta(txn, key)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = item.Value(func(val []byte) error {
+		value, err = ReadValue(txn, keyring, key, val, meta)
+		return err
+	})
+	return value, err
+}
+
+// applySecret applies a SECRET_* payload at index. A SECRET_PUT returns the
+// version it created.
+func (f FSM) applySecret(op string, payload CommandPayload, index uint64) (interface{}, error) {
+	if len(payload.Key) == 0 {
+		return nil, fmt.Errorf("secret path cannot be empty")
+	}
+	secret := payload.Secret
+	if secret == nil {
+		secret = &SecretOp{}
+	}
+
+	var result interface{}
+	err := f.update(index, func(txn *badger.Txn) error {
+		meta, err := ReadSecretMetadata(txn, payload.Key)
+		if err != nil {
+			return err
+		}
+
+		if op == "SECRET_PUT" {
+			version, err := putSecret(txn, payload, secret, meta, index)
+			result = version
+			return err
+		}
+
+		if meta == nil {
+			return fmt.Errorf("%w: %s", ErrSecretNotFound, payload.Key)
+		}
+		if op == "SECRET_PURGE" {
+			return purgeSecret(txn, payload.Key, meta)
+		}
+
+		versions := secret.Versions
+		if len(versions) == 0 {
+			versions = []uint64{meta.CurrentVersion}
+		}
+		for _, version := range versions {
+			state, ok := meta.Versions[version]
+			if !ok {
+				return fmt.Errorf("%w: version %d of %s", ErrSecretNotFound, version, payload.Key)
+			}
+			switch op {
+			case "SECRET_DELETE":
+				if state.DeleteIndex == 0 && !state.Destroyed {
+					state.DeleteIndex = index
+				}
+			case "SECRET_UNDELETE":
+				if !state.Destroyed {
+					state.DeleteIndex = 0
+				}
+			case "SECRET_DESTROY":
+				if err := destroySecretVersion(txn, payload.Key, version); err != nil {
+					return err
+				}
+				state.Destroyed = true
+			default:
+				return fmt.Errorf("unsupported secret operation %q", op)
+			}
+		}
+		meta.ModIndex = index
+		return writeSecretMetadata(txn, payload.Key, meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// putSecret stores the value of payload as the next version of a secret and
+// prunes the versions beyond its limit. payload.CASIndex, when set, must equal
+// the current version (0 for a new secret).
+func putSecret(txn *badger.Txn, payload CommandPayload, secret *SecretOp, meta *SecretMetadata, index uint64) (uint64, error) {
+	if payload.Value == nil && payload.Data == nil {
+		return 0, fmt.Errorf("value cannot be empty")
+	}
+	if meta == nil {
+		meta = &SecretMetadata{
+			MaxVersions: DefaultSecretVersions,
+			CreateIndex: index,
+			Versions:    make(map[uint64]*SecretVersion),
+		}
+	}
+	if payload.CASIndex != nil && *payload.CASIndex != meta.CurrentVersion {
+		return 0, &ConflictError{
+			Key:      payload.Key,
+			Reason:   fmt.Sprintf("expected version %d, found %d", *payload.CASIndex, meta.CurrentVersion),
+			ModIndex: meta.ModIndex,
+		}
+	}
+	if secret.MaxVersions > 0 {
+		meta.MaxVersions = secret.MaxVersions
+	}
+
+	data, valueType, err := EncodeValue(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	version := meta.CurrentVersion + 1
+	key := secretVersionKey(payload.Key, version)
+	// Versions carry a KeyMeta like plain keys, so sealed versions are
+	// snapshotted and re-encrypted the same way
+	metaData, err := json.Marshal(KeyMeta{
+		CreateIndex: index,
+		ModIndex:    index,
+		Type:        valueType,
+		KeyID:       payload.KeyID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := txn.Set([]byte(key), data); err != nil {
+		return 0, err
+	}
+	if err := txn.Set(metaKey(key), metaData); err != nil {
+		return 0, err
+	}
+
+	meta.CurrentVersion = version
+	meta.ModIndex = index
+	meta.Versions[version] = &SecretVersion{CreateIndex: index}
+
+	// Drop the oldest versions beyond the limit
+	kept := make([]uint64, 0, len(meta.Versions))
+	for v := range meta.Versions {
+		kept = append(kept, v)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i] < kept[j] })
+	for len(kept) > meta.MaxVersions {
+		if err := destroySecretVersion(txn, payload.Key, kept[0]); err != nil {
+			return 0, err
+		}
+		delete(meta.Versions, kept[0])
+		kept = kept[1:]
+	}
+	meta.OldestVersion = kept[0]
+
+	return version, writeSecretMetadata(txn, payload.Key, meta)
+}
+
+// purgeSecret removes a secret with all its versions and metadata.
+func purgeSecret(txn *badger.Txn, path string, meta *SecretMetadata) error {
+	for version := range meta.Versions {
+		if err := destroySecretVersion(txn, path, version); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(secretMetaKey(path))
+}
+
+// destroySecretVersion removes the value of a version and its KeyMeta.
+func destroySecretVersion(txn *badger.Txn, path string, version uint64) error {
+	key := secretVersionKey(path, version)
+	if err := txn.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return txn.Delete(metaKey(key))
+}
+
+func writeSecretMetadata(txn *badger.Txn, path string, meta *SecretMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return txn.Set(secretMetaKey(path), data)
+}
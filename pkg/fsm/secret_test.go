@@ -0,0 +1,112 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSM_Secrets(t *testing.T) {
+	fsm, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	index := uint64(0)
+	apply := func(payload CommandPayload) *ApplyResponse {
+		index++
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		return fsm.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data}).(*ApplyResponse)
+	}
+	put := func(value interface{}, maxVersions int) *ApplyResponse {
+		return apply(CommandPayload{Operation: "SECRET_PUT", Key: "db-creds", Value: value, Secret: &SecretOp{MaxVersions: maxVersions}})
+	}
+	metadata := func(db *badger.DB) *SecretMetadata {
+		var meta *SecretMetadata
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			var err error
+			meta, err = ReadSecretMetadata(txn, "db-creds")
+			return err
+		}))
+		return meta
+	}
+	version := func(db *badger.DB, v uint64) interface{} {
+		var value interface{}
+		require.NoError(t, db.View(func(txn *badger.Txn) error {
+			var err error
+			value, err = ReadSecretVersion(txn, nil, "db-creds", v)
+			return err
+		}))
+		return value
+	}
+
+	for i := 1; i <= 4; i++ {
+		resp := put(map[string]interface{}{"password": i}, 3)
+		require.NoError(t, resp.Error)
+		assert.Equal(t, uint64(i), resp.Data)
+	}
+
+	// Only the last three versions are kept
+	meta := metadata(db)
+	assert.Equal(t, uint64(4), meta.CurrentVersion)
+	assert.Equal(t, uint64(2), meta.OldestVersion)
+	assert.Len(t, meta.Versions, 3)
+	assert.Nil(t, version(db, 1))
+	assert.Equal(t, map[string]interface{}{"password": float64(2)}, version(db, 2))
+
+	t.Run("Check And Set", func(t *testing.T) {
+		stale := uint64(3)
+		resp := apply(CommandPayload{Operation: "SECRET_PUT", Key: "db-creds", Value: "x", CASIndex: &stale})
+		var conflict *ConflictError
+		assert.ErrorAs(t, resp.Error, &conflict)
+		assert.Equal(t, uint64(4), metadata(db).CurrentVersion)
+	})
+
+	t.Run("Delete And Undelete", func(t *testing.T) {
+		require.NoError(t, apply(CommandPayload{Operation: "SECRET_DELETE", Key: "db-creds"}).Error)
+		assert.NotZero(t, metadata(db).Versions[4].DeleteIndex)
+		// A soft delete keeps the value
+		assert.NotNil(t, version(db, 4))
+
+		require.NoError(t, apply(CommandPayload{Operation: "SECRET_UNDELETE", Key: "db-creds", Secret: &SecretOp{Versions: []uint64{4}}}).Error)
+		assert.Zero(t, metadata(db).Versions[4].DeleteIndex)
+
+		resp := apply(CommandPayload{Operation: "SECRET_DELETE", Key: "db-creds", Secret: &SecretOp{Versions: []uint64{1}}})
+		assert.ErrorIs(t, resp.Error, ErrSecretNotFound)
+	})
+
+	t.Run("Destroy", func(t *testing.T) {
+		require.NoError(t, apply(CommandPayload{Operation: "SECRET_DESTROY", Key: "db-creds", Secret: &SecretOp{Versions: []uint64{2}}}).Error)
+		assert.True(t, metadata(db).Versions[2].Destroyed)
+		assert.Nil(t, version(db, 2))
+
+		// A destroyed version cannot be undeleted
+		require.NoError(t, apply(CommandPayload{Operation: "SECRET_UNDELETE", Key: "db-creds", Secret: &SecretOp{Versions: []uint64{2}}}).Error)
+		assert.Nil(t, version(db, 2))
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		snapshot, err := fsm.Snapshot()
+		require.NoError(t, err)
+		sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
+		require.NoError(t, snapshot.Persist(sink))
+		snapshot.Release()
+
+		restored, restoredDB, _ := setupTestFSM(t)
+		defer func() { _ = restoredDB.Close() }()
+		require.NoError(t, restored.Restore(io.NopCloser(sink.Buffer)))
+		assert.Equal(t, metadata(db), metadata(restoredDB))
+		assert.Equal(t, map[string]interface{}{"password": float64(4)}, version(restoredDB, 4))
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		require.NoError(t, apply(CommandPayload{Operation: "SECRET_PURGE", Key: "db-creds"}).Error)
+		assert.Nil(t, metadata(db))
+		assert.Nil(t, version(db, 4))
+	})
+}
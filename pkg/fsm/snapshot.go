@@ -0,0 +1,100 @@
+package fsm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+)
+
+// snapshot streams the whole BadgerDB keyspace into a raft.SnapshotSink.
+// The output is a JSON array of CommandPayload objects, which is the format
+// FSM.Restore reads back.
+type snapshot struct {
+	txn *badger.Txn
+}
+
+// Persist persist to disk. Return nil on success, otherwise return error.
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.write(sink); err != nil {
+		_ = sink.Cancel()
+		return fmt.Errorf("error persisting snapshot: %w", err)
+	}
+	return sink.Close()
+}
+
+// write encodes every key/value visible to the pinned transaction.
+func (s *snapshot) write(sink raft.SnapshotSink) error {
+	w := bufio.NewWriter(sink)
+
+	it := s.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	if _, err := w.WriteString("["); err != nil {
+		return err
+	}
+
+	first := true
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		payload := CommandPayload{
+			Operation: "SET",
+			Key:       string(item.Key()),
+			ExpiresAt: item.ExpiresAt(),
+		}
+
+		// Binary and sealed values are not JSON and are carried as raw bytes
+		meta := KeyMeta{}
+		if !IsReservedKey(payload.Key) || IsSecretVersionKey(payload.Key) {
+			if meta, err = ReadMeta(s.txn, payload.Key); err != nil {
+				return err
+			}
+		}
+		if meta.Type == ValueTypeBinary || meta.KeyID != "" {
+			payload.Data = value
+		} else {
+			payload.Value = json.RawMessage(value)
+		}
+
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			if _, err := w.WriteString(","); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.WriteString("]"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Release release the lock after persist snapshot.
+// Release is invoked when we are finished with the snapshot.
+func (s *snapshot) Release() {
+	s.txn.Discard()
+}
+
+// newSnapshot is returned by an FSM in response to a Snapshot call.
+// It must be safe to invoke FSMSnapshot methods with concurrent
+// calls to Apply.
+func newSnapshot(db *badger.DB) raft.FSMSnapshot {
+	return &snapshot{txn: db.NewTransaction(false)}
+}
@@ -1,22 +0,0 @@
-package fsm
-
-import (
-	"github.com/hashicorp/raft"
-)
-
-// snapshotNoop handle noop snapshot
-type snapshotNoop struct{}
-
-// Persist persist to disk. Return nil on success, otherwise return error.
-func (s snapshotNoop) Persist(_ raft.SnapshotSink) error { return nil }
-
-// Release release the lock after persist snapshot.
-// Release is invoked when we are finished with the snapshot.
-func (s snapshotNoop) Release() {}
-
-// newSnapshotNoop is returned by an FSM in response to a snapshotNoop
-// It must be safe to invoke FSMSnapshot methods with concurrent
-// calls to Apply.
-func newSnapshotNoop() (raft.FSMSnapshot, error) {
-	return &snapshotNoop{}, nil
-}
@@ -0,0 +1,219 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// TxnGuard is a condition on a single key. Every field that is set must
+// hold for the guard to pass.
+type TxnGuard struct {
+	Key string
+	// Exists requires the key to exist (true) or to be absent (false).
+	Exists *bool `json:",omitempty"`
+	// Value requires the current value to equal it, compared as JSON.
+	Value interface{} `json:",omitempty"`
+	// ModIndex requires the key's current mod index to equal it. Zero
+	// means the key must not exist.
+	ModIndex *uint64 `json:",omitempty"`
+}
+
+// Txn is the body of a TXN payload. If every guard passes the Then
+// operations are applied, otherwise the Else operations are. Operations are
+// SET, DELETE or GET payloads and run in order in one Badger transaction, so
+// either all of them are applied or none is.
+type Txn struct {
+	Guards []TxnGuard
+	Then   []CommandPayload
+	Else   []CommandPayload
+}
+
+// TxnResult is the ApplyResponse.Data of a TXN payload.
+type TxnResult struct {
+	// Succeeded reports whether the guards passed and Then was applied.
+	Succeeded bool          `json:"succeeded"`
+	Results   []TxnOpResult `json:"results"`
+}
+
+// TxnOpResult describes the outcome of one operation of a transaction.
+type TxnOpResult struct {
+	Operation string      `json:"op"`
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value,omitempty"`
+	// ModIndex is the mod index of the key after the operation, or 0 if it
+	// does not exist.
+	ModIndex uint64 `json:"mod_index"`
+}
+
+// applyTxn evaluates the guards of t at now and applies the selected branch
+// at index. An operation that fails (for example a CAS conflict) aborts the
+// whole transaction.
+func (f FSM) applyTxn(t *Txn, index, now uint64) (*TxnResult, error) {
+	if t == nil {
+		return nil, fmt.Errorf("txn cannot be empty")
+	}
+
+	result := &TxnResult{}
+	err := f.update(index, func(txn *badger.Txn) error {
+		ok, err := f.checkGuards(txn, t.Guards, now)
+		if err != nil {
+			return err
+		}
+
+		ops := t.Else
+		if ok {
+			ops = t.Then
+		}
+		result.Succeeded = ok
+		result.Results = make([]TxnOpResult, 0, len(ops))
+
+		for _, op := range ops {
+			opResult, err := f.applyTxnOp(txn, op, index, now)
+			if err != nil {
+				return err
+			}
+			result.Results = append(result.Results, opResult)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkGuards reports whether every guard holds within txn at now.
+func (f FSM) checkGuards(txn *badger.Txn, guards []TxnGuard, now uint64) (bool, error) {
+	for _, guard := range guards {
+		item, err := getAt(txn, []byte(guard.Key), now)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return false, err
+		}
+		exists := err == nil
+
+		if guard.Exists != nil && *guard.Exists != exists {
+			return false, nil
+		}
+
+		if guard.ModIndex != nil {
+			var meta KeyMeta
+			if exists {
+				if meta, err = readMetaAt(txn, guard.Key, now); err != nil {
+					return false, err
+				}
+			}
+			if meta.ModIndex != *guard.ModIndex {
+				return false, nil
+			}
+		}
+
+		if guard.Value != nil {
+			if !exists {
+				return false, nil
+			}
+			equal, err := f.valueEquals(txn, item, guard.Value, now)
+			if err != nil || !equal {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// valueEquals compares a stored value with want after normalising both
+// through JSON, so numbers compare equal regardless of how they were decoded.
+// Binary values never compare equal; guard them on their mod index instead.
+func (f FSM) valueEquals(txn *badger.Txn, item *badger.Item, want interface{}, now uint64) (bool, error) {
+	key := string(item.Key())
+	meta, err := readMetaAt(txn, key, now)
+	if err != nil || meta.Type == ValueTypeBinary {
+		return false, err
+	}
+
+	var current interface{}
+	err = item.Value(func(val []byte) error {
+		current, err = ReadValue(txn, f.keyring, key, val, meta)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		return false, err
+	}
+	var expected interface{}
+	if err := json.Unmarshal(data, &expected); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(current, expected), nil
+}
+
+// applyTxnOp runs a single transaction operation within txn at now.
+func (f FSM) applyTxnOp(txn *badger.Txn, op CommandPayload, index, now uint64) (TxnOpResult, error) {
+	result := TxnOpResult{
+		Operation: strings.ToUpper(strings.TrimSpace(op.Operation)),
+		Key:       op.Key,
+	}
+
+	switch result.Operation {
+	case "SET":
+		if err := setInTxn(txn, op, index, now); err != nil {
+			return result, err
+		}
+		result.ModIndex = index
+	case "DELETE":
+		if err := deleteInTxn(txn, op, now); err != nil {
+			return result, err
+		}
+	case "GET":
+		item, err := getAt(txn, []byte(op.Key), now)
+		if err == badger.ErrKeyNotFound {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		meta, err := readMetaAt(txn, op.Key, now)
+		if err != nil {
+			return result, err
+		}
+		err = item.Value(func(val []byte) error {
+			result.Value, err = ReadValue(txn, f.keyring, op.Key, val, meta)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+		result.ModIndex = meta.ModIndex
+	default:
+		return result, fmt.Errorf("unsupported txn operation %q", op.Operation)
+	}
+	return result, nil
+}
+
+// txnEvents lists the changes made by the applied branch of t.
+func (f FSM) txnEvents(t *Txn, result *TxnResult, index uint64) []Event {
+	ops := t.Else
+	if result.Succeeded {
+		ops = t.Then
+	}
+
+	var events []Event
+	for _, op := range ops {
+		switch strings.ToUpper(strings.TrimSpace(op.Operation)) {
+		case "SET":
+			if op.Value != nil || op.Data != nil {
+				events = append(events, f.putEvent(op, index))
+			}
+		case "DELETE":
+			events = append(events, Event{Type: EventDelete, Key: op.Key, Index: index})
+		}
+	}
+	return events
+}
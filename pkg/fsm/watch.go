@@ -0,0 +1,259 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Event types published by a Watcher
+const (
+	EventPut    = "PUT"
+	EventDelete = "DELETE"
+)
+
+// DefaultWatchHistory is the number of recent events a Watcher keeps for
+// clients resuming from an earlier index.
+const DefaultWatchHistory = 1024
+
+// subscriptionBuffer is the number of events a subscriber may fall behind
+// before it is dropped. Apply never blocks on a slow subscriber.
+const subscriptionBuffer = 256
+
+// ErrWatchLagged ends a subscription whose consumer fell too far behind.
+// The client can resume from the last index it received.
+var ErrWatchLagged = errors.New("watcher fell behind")
+
+// ErrWatchReset ends every subscription when the FSM is restored from a
+// snapshot, since the changes it skipped are not known.
+var ErrWatchReset = errors.New("watch history was reset by a snapshot restore")
+
+// Event describes a committed change to a key.
+type Event struct {
+	Type  string      `json:"type"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+	// Index is the Raft log index of the entry that made the change.
+	// Events from one transaction share an index.
+	Index uint64 `json:"index"`
+}
+
+// putEvent describes a SET payload applied at index. Binary values are
+// published as their raw bytes and sealed values are decrypted first.
+func (f FSM) putEvent(payload CommandPayload, index uint64) Event {
+	event := Event{Type: EventPut, Key: payload.Key, Value: payload.Value, Index: index}
+	if payload.Data == nil {
+		return event
+	}
+	event.Value = payload.Data
+	if payload.KeyID != "" {
+		meta := KeyMeta{Type: payload.Type, KeyID: payload.KeyID}
+		err := f.db.View(func(txn *badger.Txn) error {
+			var err error
+			event.Value, err = ReadValue(txn, f.keyring, payload.Key, payload.Data, meta)
+			return err
+		})
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error decrypting watch event for key %s: %s\n", payload.Key, err.Error())
+		}
+	}
+	return event
+}
+
+// CompactedError is returned when resuming from an index older than the
+// watch history.
+type CompactedError struct {
+	Index uint64
+	// Oldest is the lowest index a watch can currently resume after, or 0
+	// if none can.
+	Oldest uint64
+}
+
+func (e *CompactedError) Error() string {
+	return fmt.Sprintf("index %d is no longer in the watch history (oldest %d)", e.Index, e.Oldest)
+}
+
+// Watcher fans out committed changes from FSM.Apply to subscribers and
+// keeps a bounded history of recent events.
+type Watcher struct {
+	mu      sync.Mutex
+	history []Event
+	next    int // position of the next write in history once it is full
+	size    int
+	// floor is the lowest index from which history is complete; 0 until
+	// the first event is published or the FSM reports what it applied.
+	floor uint64
+	subs  map[*Subscription]struct{}
+}
+
+// NewWatcher creates a Watcher that keeps the last size events.
+func NewWatcher(size int) *Watcher {
+	if size <= 0 {
+		size = DefaultWatchHistory
+	}
+	return &Watcher{
+		history: make([]Event, 0, size),
+		size:    size,
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events matching its filter.
+type Subscription struct {
+	events  chan Event
+	match   func(key string) bool
+	watcher *Watcher
+	err     error
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription ends; Err then reports why.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns the reason the subscription ended, or nil if it is still
+// open or was closed by its owner.
+func (s *Subscription) Err() error {
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+	s.watcher.remove(s, nil)
+}
+
+// Subscribe registers a subscriber for the keys accepted by match. When
+// afterIndex is non-zero, the events after it are replayed from history
+// first; a *CompactedError is returned if they are no longer available.
+func (w *Watcher) Subscribe(match func(key string) bool, afterIndex uint64) (*Subscription, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var replay []Event
+	if afterIndex > 0 {
+		// Without history the events after afterIndex are unknown
+		if w.floor == 0 || afterIndex+1 < w.floor {
+			return nil, &CompactedError{Index: afterIndex, Oldest: w.oldest()}
+		}
+		for _, event := range w.ordered() {
+			if event.Index > afterIndex && match(event.Key) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	s := &Subscription{
+		events:  make(chan Event, subscriptionBuffer+len(replay)),
+		match:   match,
+		watcher: w,
+	}
+	for _, event := range replay {
+		s.events <- event
+	}
+	w.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Publish records events and delivers them to matching subscribers.
+func (w *Watcher) Publish(events ...Event) {
+	if w == nil || len(events) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, event := range events {
+		if w.floor == 0 {
+			w.floor = event.Index
+		}
+		if len(w.history) < w.size {
+			w.history = append(w.history, event)
+		} else {
+			w.floor = w.history[w.next].Index + 1
+			w.history[w.next] = event
+			w.next = (w.next + 1) % w.size
+		}
+
+		for s := range w.subs {
+			if !s.match(event.Key) {
+				continue
+			}
+			select {
+			case s.events <- event:
+			default:
+				w.remove(s, ErrWatchLagged)
+			}
+		}
+	}
+}
+
+// Reset clears the history and ends every subscription. It is called when
+// the FSM state is replaced by a snapshot.
+func (w *Watcher) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.history = w.history[:0]
+	w.next = 0
+	w.floor = 0
+	for s := range w.subs {
+		w.remove(s, ErrWatchReset)
+	}
+}
+
+// resume marks the history complete after applied, the last index the FSM
+// applied: every later change is published, so a watch resuming at or after
+// applied misses nothing even though the history is empty. A watcher that
+// already has history is left as it is.
+func (w *Watcher) resume(applied uint64) {
+	if w == nil || applied == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.floor == 0 {
+		w.floor = applied + 1
+	}
+}
+
+// oldest returns the lowest index a watch can resume after, or 0 if there
+// is no history. Callers must hold w.mu.
+func (w *Watcher) oldest() uint64 {
+	if w.floor == 0 {
+		return 0
+	}
+	return w.floor - 1
+}
+
+// ordered returns the history oldest first. Callers must hold w.mu.
+func (w *Watcher) ordered() []Event {
+	if len(w.history) < w.size {
+		return w.history
+	}
+	return append(append([]Event{}, w.history[w.next:]...), w.history[:w.next]...)
+}
+
+// remove ends s with err. Callers must hold w.mu.
+func (w *Watcher) remove(s *Subscription, err error) {
+	if _, ok := w.subs[s]; !ok {
+		return
+	}
+	delete(w.subs, s)
+	s.err = err
+	close(s.events)
+}
@@ -0,0 +1,184 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, s *Subscription, n int) []Event {
+	t.Helper()
+	var events []Event
+	for i := 0; i < n; i++ {
+		select {
+		case event, ok := <-s.Events():
+			require.True(t, ok, "subscription closed early")
+			events = append(events, event)
+		default:
+			t.Fatalf("expected %d events, got %d", n, len(events))
+		}
+	}
+	return events
+}
+
+func TestWatcher(t *testing.T) {
+	all := func(string) bool { return true }
+	prefix := func(p string) func(string) bool {
+		return func(key string) bool { return strings.HasPrefix(key, p) }
+	}
+
+	t.Run("Delivers Matching Events", func(t *testing.T) {
+		w := NewWatcher(10)
+		s, err := w.Subscribe(prefix("app/"), 0)
+		require.NoError(t, err)
+		defer s.Close()
+
+		w.Publish(Event{Type: EventPut, Key: "app/a", Index: 1}, Event{Type: EventPut, Key: "other", Index: 2})
+		w.Publish(Event{Type: EventDelete, Key: "app/a", Index: 3})
+
+		events := receive(t, s, 2)
+		assert.Equal(t, uint64(1), events[0].Index)
+		assert.Equal(t, EventDelete, events[1].Type)
+	})
+
+	t.Run("Resumes From History", func(t *testing.T) {
+		w := NewWatcher(3)
+		for i := uint64(1); i <= 5; i++ {
+			w.Publish(Event{Type: EventPut, Key: "k", Index: i})
+		}
+
+		s, err := w.Subscribe(all, 3)
+		require.NoError(t, err)
+		events := receive(t, s, 2)
+		assert.Equal(t, uint64(4), events[0].Index)
+		assert.Equal(t, uint64(5), events[1].Index)
+		s.Close()
+
+		_, err = w.Subscribe(all, 1)
+		var compacted *CompactedError
+		require.ErrorAs(t, err, &compacted)
+		assert.Equal(t, uint64(2), compacted.Oldest)
+
+		_, err = NewWatcher(3).Subscribe(all, 1)
+		assert.ErrorAs(t, err, &compacted)
+	})
+
+	t.Run("Drops Slow Subscribers", func(t *testing.T) {
+		w := NewWatcher(10)
+		s, err := w.Subscribe(all, 0)
+		require.NoError(t, err)
+
+		for i := uint64(1); i <= subscriptionBuffer+1; i++ {
+			w.Publish(Event{Type: EventPut, Key: "k", Index: i})
+		}
+		for range s.Events() {
+		}
+		assert.ErrorIs(t, s.Err(), ErrWatchLagged)
+	})
+
+	t.Run("Reset Ends Subscriptions", func(t *testing.T) {
+		w := NewWatcher(10)
+		s, err := w.Subscribe(all, 0)
+		require.NoError(t, err)
+		w.Reset()
+		_, ok := <-s.Events()
+		assert.False(t, ok)
+		assert.ErrorIs(t, s.Err(), ErrWatchReset)
+	})
+}
+
+func TestFSM_ApplyPublishesEvents(t *testing.T) {
+	_, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	w := NewWatcher(10)
+	f := NewWithWatcher(db, w)
+	s, err := w.Subscribe(func(string) bool { return true }, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	apply := func(index uint64, payload CommandPayload) {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		f.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data})
+	}
+
+	apply(1, CommandPayload{Operation: "SET", Key: "a", Value: "v"})
+	apply(2, CommandPayload{Operation: "SET", Key: "a", Value: "x", Mode: WriteModeCreate}) // conflict, no event
+	apply(3, CommandPayload{Operation: "GET", Key: "a"})
+	apply(4, CommandPayload{Operation: "TXN", Txn: &Txn{Then: []CommandPayload{
+		{Operation: "SET", Key: "b", Value: "w"},
+		{Operation: "DELETE", Key: "a"},
+	}}})
+
+	events := receive(t, s, 3)
+	assert.Equal(t, Event{Type: EventPut, Key: "a", Value: "v", Index: 1}, events[0])
+	assert.Equal(t, Event{Type: EventPut, Key: "b", Value: "w", Index: 4}, events[1])
+	assert.Equal(t, Event{Type: EventDelete, Key: "a", Index: 4}, events[2])
+	select {
+	case event := <-s.Events():
+		t.Fatalf("unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestFSM_WatchResumesAfterRestart(t *testing.T) {
+	_, db, _ := setupTestFSM(t)
+	defer func() { _ = db.Close() }()
+
+	apply := func(f *FSM, index uint64, payload CommandPayload) {
+		data, err := json.Marshal(payload)
+		require.NoError(t, err)
+		f.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data})
+	}
+	f := NewWithWatcher(db, NewWatcher(10)).(*FSM)
+	apply(f, 1, CommandPayload{Operation: "SET", Key: "a", Value: "v"})
+	apply(f, 2, CommandPayload{Operation: "SET", Key: "other", Value: "v"})
+	apply(f, 3, CommandPayload{Operation: "SET", Key: "a", Value: "w"})
+
+	// A restart loses the history but not the applied index
+	w := NewWatcher(10)
+	f = NewWithWatcher(db, w).(*FSM)
+	all := func(string) bool { return true }
+
+	s, err := w.Subscribe(all, 3)
+	require.NoError(t, err, "a caught up client resumes live")
+	defer s.Close()
+	apply(f, 3, CommandPayload{Operation: "SET", Key: "a", Value: "w"}) // replayed, no event
+	apply(f, 4, CommandPayload{Operation: "DELETE", Key: "a"})
+	assert.Equal(t, []Event{{Type: EventDelete, Key: "a", Index: 4}}, receive(t, s, 1))
+
+	// The events after index 1 were published before the restart
+	_, err = w.Subscribe(all, 1)
+	var compacted *CompactedError
+	require.ErrorAs(t, err, &compacted)
+	assert.Equal(t, uint64(3), compacted.Oldest)
+
+	// A snapshot restore ends subscriptions and resumes after the snapshot
+	snapshot, err := f.Snapshot()
+	require.NoError(t, err)
+	sink := &mockSnapshotSink{Buffer: new(bytes.Buffer)}
+	require.NoError(t, snapshot.Persist(sink))
+	snapshot.Release()
+
+	restored := NewWatcher(10)
+	f = NewWithWatcher(db, restored).(*FSM)
+	s, err = restored.Subscribe(all, 4)
+	require.NoError(t, err)
+	require.NoError(t, f.Restore(io.NopCloser(sink.Buffer)))
+	_, ok := <-s.Events()
+	assert.False(t, ok)
+	assert.ErrorIs(t, s.Err(), ErrWatchReset)
+
+	s, err = restored.Subscribe(all, 4)
+	require.NoError(t, err)
+	s.Close()
+	_, err = restored.Subscribe(all, 3)
+	assert.ErrorAs(t, err, &compacted)
+}
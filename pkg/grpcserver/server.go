@@ -0,0 +1,343 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/subash-0044/beaver-vault/pkg/consensus"
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+	"github.com/subash-0044/beaver-vault/pkg/pb"
+)
+
+// Server represents the gRPC server. It exposes the same operations as the
+// HTTP server on top of the same handler and consensus objects.
+type Server struct {
+	pb.UnimplementedBeaverVaultServer
+
+	handler   *handler.Handler
+	consensus *consensus.Raft
+	grpc      *grpc.Server
+}
+
+// NewGRPCServer creates a new gRPC server instance. opts are passed to the
+// underlying grpc.Server, e.g. to serve TLS.
+func NewGRPCServer(h *handler.Handler, c *consensus.Raft, opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		handler:   h,
+		consensus: c,
+		grpc:      grpc.NewServer(opts...),
+	}
+	pb.RegisterBeaverVaultServer(s.grpc, s)
+	return s
+}
+
+// Run starts the gRPC server
+func (s *Server) Run(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.grpc.Serve(lis)
+}
+
+// Stop stops the gRPC server, waiting for in-flight calls to finish
+func (s *Server) Stop() {
+	s.grpc.GracefulStop()
+}
+
+// Shutdown stops the gRPC server like Stop, but cancels the calls still
+// running when ctx expires, such as watch streams.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Get handles Get calls for key-value pairs
+func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	if err := s.authorize(ctx, handler.KVResource(req.GetKey()), handler.CapabilityRead); err != nil {
+		return nil, err
+	}
+	consistency, err := handler.ParseConsistency(req.GetConsistency())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	entry, err := s.handler.GetEntry(req.GetKey(), consistency)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if entry == nil || entry.Value == nil {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+
+	resp := &pb.GetResponse{
+		Key:         req.GetKey(),
+		CreateIndex: entry.CreateIndex,
+		ModIndex:    entry.ModIndex,
+	}
+	if entry.Type == fsm.ValueTypeBinary {
+		resp.BinaryValue = entry.Value.([]byte)
+		return resp, nil
+	}
+
+	if resp.Value, err = structpb.NewValue(entry.Value); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// Put handles Put calls for key-value pairs
+func (s *Server) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	if err := s.authorize(ctx, handler.KVResource(req.GetKey()), handler.CapabilityWrite); err != nil {
+		return nil, err
+	}
+	form := handler.RequestStore{
+		Key:      req.GetKey(),
+		TTL:      req.GetTtl().AsDuration(),
+		Mode:     req.GetMode(),
+		CASIndex: req.CasIndex,
+	}
+	switch {
+	case len(req.GetBinaryValue()) > 0:
+		form.Data = req.GetBinaryValue()
+		if req.GetValue() != nil {
+			return nil, status.Error(codes.InvalidArgument, "value and binary_value cannot both be set")
+		}
+	case req.GetValue() != nil:
+		form.Value = req.GetValue().AsInterface()
+	default:
+		return nil, status.Error(codes.InvalidArgument, "value is empty")
+	}
+
+	if err := s.handler.Store(ctx, form); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.PutResponse{}, nil
+}
+
+// Delete handles Delete calls for key-value pairs
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := s.authorize(ctx, handler.KVResource(req.GetKey()), handler.CapabilityWrite); err != nil {
+		return nil, err
+	}
+	if err := s.handler.DeleteWithCAS(req.GetKey(), req.CasIndex); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+// Watch streams changes of a key or prefix until the client cancels the call
+func (s *Server) Watch(req *pb.WatchRequest, stream grpc.ServerStreamingServer[pb.WatchEvent]) error {
+	watched := req.GetKey()
+	if watched == "" {
+		watched = req.GetPrefix()
+	}
+	if err := s.authorize(stream.Context(), handler.KVResource(watched), handler.CapabilityRead); err != nil {
+		return err
+	}
+	sub, err := s.handler.Watch(handler.RequestWatch{
+		Key:        req.GetKey(),
+		Prefix:     req.GetPrefix(),
+		AfterIndex: req.GetAfterIndex(),
+	})
+	if err != nil {
+		var compacted *fsm.CompactedError
+		if errors.As(err, &compacted) {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					return status.Error(codes.Aborted, err.Error())
+				}
+				return nil
+			}
+
+			msg := &pb.WatchEvent{Type: event.Type, Key: event.Key, Index: event.Index}
+			if raw, ok := event.Value.([]byte); ok {
+				msg.BinaryValue = raw
+			} else if event.Value != nil {
+				if msg.Value, err = structpb.NewValue(event.Value); err != nil {
+					return status.Error(codes.Internal, err.Error())
+				}
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Join handles calls to join a new node to the Raft cluster
+func (s *Server) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinResponse, error) {
+	if err := s.authorize(ctx, handler.ResourceRaft, handler.CapabilityAdmin); err != nil {
+		return nil, err
+	}
+	success, err := s.consensus.JoinRaftHandler(consensus.RequestJoin{
+		NodeID:      req.GetNodeId(),
+		RaftAddress: req.GetRaftAddress(),
+		HTTPAddress: req.GetHttpAddress(),
+		Suffrage:    req.GetSuffrage(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.JoinResponse{Success: success}, nil
+}
+
+// Drop handles calls to remove a node from the Raft cluster
+func (s *Server) Drop(ctx context.Context, req *pb.DropRequest) (*pb.DropResponse, error) {
+	if err := s.authorize(ctx, handler.ResourceRaft, handler.CapabilityAdmin); err != nil {
+		return nil, err
+	}
+	result, err := s.consensus.DropRaftHandler(consensus.RequestDrop{
+		NodeID: req.GetNodeId(),
+		Force:  req.GetForce(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if !result.Removed && result.TransferredTo != "" {
+		// This leader handed leadership over instead of dropping itself.
+		// Unlike the HTTP API it does not forward, so the caller has to
+		// send the drop again.
+		return nil, status.Errorf(codes.Unavailable, "leadership transferred to %s; send the drop to the new leader", result.TransferredTo)
+	}
+	return &pb.DropResponse{
+		Success:   true,
+		Suffrage:  result.Suffrage,
+		Removed:   result.Removed,
+		Forced:    result.Forced,
+		Voters:    int32(result.Voters),
+		Nonvoters: int32(result.Nonvoters),
+	}, nil
+}
+
+// Promote handles calls to make a non-voter a voter
+func (s *Server) Promote(ctx context.Context, req *pb.PromoteRequest) (*pb.PromoteResponse, error) {
+	if err := s.authorize(ctx, handler.ResourceRaft, handler.CapabilityAdmin); err != nil {
+		return nil, err
+	}
+	success, err := s.consensus.PromoteRaftHandler(consensus.RequestSuffrage{NodeID: req.GetNodeId()})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.PromoteResponse{Success: success}, nil
+}
+
+// Demote handles calls to make a voter a non-voter
+func (s *Server) Demote(ctx context.Context, req *pb.DemoteRequest) (*pb.DemoteResponse, error) {
+	if err := s.authorize(ctx, handler.ResourceRaft, handler.CapabilityAdmin); err != nil {
+		return nil, err
+	}
+	success, err := s.consensus.DemoteRaftHandler(consensus.RequestSuffrage{NodeID: req.GetNodeId(), Force: req.GetForce()})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DemoteResponse{Success: success}, nil
+}
+
+// Stats handles calls to retrieve Raft cluster stats
+func (s *Server) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.StatsResponse, error) {
+	if err := s.authorize(ctx, handler.ResourceRaft, handler.CapabilityRead); err != nil {
+		return nil, err
+	}
+	stats, err := s.consensus.StatsRaftHandler()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.StatsResponse{Stats: stats}, nil
+}
+
+// Members handles calls to list the members of the Raft cluster
+func (s *Server) Members(ctx context.Context, _ *pb.MembersRequest) (*pb.MembersResponse, error) {
+	if err := s.authorize(ctx, handler.ResourceRaft, handler.CapabilityRead); err != nil {
+		return nil, err
+	}
+	members, err := s.consensus.MembersRaftHandler()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.MembersResponse{Members: make([]*pb.Member, 0, len(members))}
+	for _, m := range members {
+		member := &pb.Member{Id: m.ID, Address: m.Address, Suffrage: m.Suffrage, Leader: m.Leader}
+		if m.Progress != nil {
+			member.Progress = &pb.MemberProgress{MatchIndex: m.Progress.MatchIndex, Lag: m.Progress.Lag}
+			if !m.Progress.LastContact.IsZero() {
+				member.Progress.LastContact = timestamppb.New(m.Progress.LastContact)
+			}
+		}
+		resp.Members = append(resp.Members, member)
+	}
+	return resp, nil
+}
+
+// authorize checks the bearer token in the "authorization" metadata of a
+// call against resource, like the HTTP server does for its routes.
+func (s *Server) authorize(ctx context.Context, resource, capability string) error {
+	var secret string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			secret = strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+		}
+	}
+	if _, err := s.handler.Check(secret, resource, capability); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// toStatus maps handler and consensus errors to gRPC status codes, the same
+// way the HTTP server maps them to status codes.
+func toStatus(err error) error {
+	var conflict *fsm.ConflictError
+	if errors.As(err, &conflict) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	if errors.Is(err, handler.ErrUnauthenticated) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if errors.Is(err, handler.ErrPermissionDenied) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, handler.ErrWriteQueueFull) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	const errNotLeader = "not the leader"
+	if strings.HasPrefix(err.Error(), errNotLeader) || handler.IsLeadershipError(err) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
@@ -0,0 +1,267 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/subash-0044/beaver-vault/pkg/consensus"
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+	"github.com/subash-0044/beaver-vault/pkg/pb"
+)
+
+func setupTestServer(t *testing.T) (pb.BeaverVaultClient, func()) {
+	tmpDir, err := os.MkdirTemp("", "raft-test-grpc")
+	require.NoError(t, err)
+
+	badgerOpts := badger.DefaultOptions(filepath.Join(tmpDir, "badger"))
+	badgerOpts.Logger = nil
+	db, err := badger.Open(badgerOpts)
+	require.NoError(t, err)
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID("node1")
+	config.HeartbeatTimeout = 100 * time.Millisecond
+	config.ElectionTimeout = 100 * time.Millisecond
+	config.LeaderLeaseTimeout = 100 * time.Millisecond
+	config.CommitTimeout = 10 * time.Millisecond
+
+	snapshotStore, err := raft.NewFileSnapshotStore(tmpDir, 1, nil)
+	require.NoError(t, err)
+
+	transport, err := raft.NewTCPTransport("localhost:0", nil, 3, 10*time.Second, nil)
+	require.NoError(t, err)
+
+	watcher := fsm.NewWatcher(fsm.DefaultWatchHistory)
+	ra, err := raft.NewRaft(config, fsm.NewWithWatcher(db, watcher), raft.NewInmemStore(), raft.NewInmemStore(), snapshotStore, transport)
+	require.NoError(t, err)
+
+	ra.BootstrapCluster(raft.Configuration{
+		Servers: []raft.Server{{ID: config.LocalID, Address: transport.LocalAddr()}},
+	})
+
+	timeout := time.Now().Add(5 * time.Second)
+	for time.Now().Before(timeout) && ra.State() != raft.Leader {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Equal(t, raft.Leader, ra.State(), "node1 should become leader")
+
+	s := NewGRPCServer(handler.NewActionHandler(ra, db).WithWatcher(watcher), consensus.NewRaftObj(ra))
+	lis := bufconn.Listen(1024 * 1024)
+	go func() { _ = s.grpc.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	cleanup := func() {
+		_ = conn.Close()
+		s.Stop()
+		_ = ra.Shutdown().Error()
+		_ = transport.Close()
+		_ = db.Close()
+		_ = os.RemoveAll(tmpDir)
+	}
+
+	return pb.NewBeaverVaultClient(conn), cleanup
+}
+
+func TestKeyValueOperations(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	value, err := structpb.NewValue(map[string]interface{}{"name": "test", "age": 30})
+	require.NoError(t, err)
+
+	t.Run("Put", func(t *testing.T) {
+		_, err := client.Put(ctx, &pb.PutRequest{Key: "test-key", Value: value})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		for _, consistency := range []string{"", "leader", "linearizable"} {
+			resp, err := client.Get(ctx, &pb.GetRequest{Key: "test-key", Consistency: consistency})
+			require.NoError(t, err, consistency)
+			assert.Equal(t, "test-key", resp.GetKey())
+			assert.Equal(t, value.AsInterface(), resp.GetValue().AsInterface())
+		}
+	})
+
+	t.Run("Get Non-existent Key", func(t *testing.T) {
+		_, err := client.Get(ctx, &pb.GetRequest{Key: "non-existent"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Invalid Arguments", func(t *testing.T) {
+		_, err := client.Put(ctx, &pb.PutRequest{Key: "", Value: value})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.Get(ctx, &pb.GetRequest{Key: "test-key", Consistency: "bogus"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Compare And Swap", func(t *testing.T) {
+		resp, err := client.Get(ctx, &pb.GetRequest{Key: "test-key"})
+		require.NoError(t, err)
+		assert.NotZero(t, resp.GetModIndex())
+
+		stale := resp.GetModIndex() - 1
+		_, err = client.Put(ctx, &pb.PutRequest{Key: "test-key", Value: value, CasIndex: &stale})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+
+		current := resp.GetModIndex()
+		_, err = client.Put(ctx, &pb.PutRequest{Key: "test-key", Value: value, CasIndex: &current})
+		assert.NoError(t, err)
+
+		_, err = client.Put(ctx, &pb.PutRequest{Key: "test-key", Value: value, Mode: "create"})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+	})
+
+	t.Run("Binary Value", func(t *testing.T) {
+		blob := []byte{0x00, 0xff, 0x10}
+		_, err := client.Put(ctx, &pb.PutRequest{Key: "blob", BinaryValue: blob})
+		require.NoError(t, err)
+
+		resp, err := client.Get(ctx, &pb.GetRequest{Key: "blob"})
+		require.NoError(t, err)
+		assert.Equal(t, blob, resp.GetBinaryValue())
+		assert.Nil(t, resp.GetValue())
+
+		_, err = client.Put(ctx, &pb.PutRequest{Key: "blob", Value: value, BinaryValue: blob})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := client.Delete(ctx, &pb.DeleteRequest{Key: "test-key"})
+		assert.NoError(t, err)
+
+		_, err = client.Get(ctx, &pb.GetRequest{Key: "test-key", Consistency: "linearizable"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		resp, err := client.Stats(ctx, &pb.StatsRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "Leader", resp.GetStats()["state"])
+	})
+
+	t.Run("Members", func(t *testing.T) {
+		resp, err := client.Members(ctx, &pb.MembersRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.GetMembers(), 1)
+		member := resp.GetMembers()[0]
+		assert.Equal(t, "node1", member.GetId())
+		assert.Equal(t, "voter", member.GetSuffrage())
+		assert.True(t, member.GetLeader())
+		assert.Nil(t, member.GetProgress())
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		_, err := client.Drop(ctx, &pb.DropRequest{NodeId: "node9"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.Drop(ctx, &pb.DropRequest{NodeId: "node1", Force: true})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "last voter")
+	})
+}
+
+func TestWatch(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Prefix: "app/"})
+	require.NoError(t, err)
+
+	_, err = client.Put(ctx, &pb.PutRequest{Key: "other", Value: structpb.NewStringValue("x")})
+	require.NoError(t, err)
+	_, err = client.Put(ctx, &pb.PutRequest{Key: "app/a", Value: structpb.NewStringValue("v1")})
+	require.NoError(t, err)
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Key: "app/a"})
+	require.NoError(t, err)
+
+	put, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "PUT", put.GetType())
+	assert.Equal(t, "app/a", put.GetKey())
+	assert.Equal(t, "v1", put.GetValue().GetStringValue())
+
+	del, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE", del.GetType())
+	assert.Greater(t, del.GetIndex(), put.GetIndex())
+
+	// Resume after the put from history
+	resumed, err := client.Watch(ctx, &pb.WatchRequest{Key: "app/a", AfterIndex: put.GetIndex()})
+	require.NoError(t, err)
+	event, err := resumed.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, del.GetIndex(), event.GetIndex())
+
+	invalid, err := client.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	compacted, err := client.Watch(ctx, &pb.WatchRequest{Key: "app/a", AfterIndex: 1})
+	require.NoError(t, err)
+	_, err = compacted.Recv()
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func TestDropSelf(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// A second voter to hand leadership to
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID("node2")
+	config.HeartbeatTimeout = 100 * time.Millisecond
+	config.ElectionTimeout = 100 * time.Millisecond
+	config.LeaderLeaseTimeout = 100 * time.Millisecond
+	config.CommitTimeout = 10 * time.Millisecond
+	transport, err := raft.NewTCPTransport("localhost:0", nil, 3, 10*time.Second, nil)
+	require.NoError(t, err)
+	defer func() { _ = transport.Close() }()
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	ra, err := raft.NewRaft(config, fsm.New(db), raft.NewInmemStore(), raft.NewInmemStore(), raft.NewInmemSnapshotStore(), transport)
+	require.NoError(t, err)
+	defer func() { _ = ra.Shutdown().Error() }()
+
+	_, err = client.Join(ctx, &pb.JoinRequest{NodeId: "node2", RaftAddress: string(transport.LocalAddr())})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, id := ra.LeaderWithID()
+		return id == "node1"
+	}, 5*time.Second, 50*time.Millisecond, "node2 should hear from the leader")
+
+	// The leader hands over instead of dropping itself, so the drop is not done
+	_, err = client.Drop(ctx, &pb.DropRequest{NodeId: "node1", Force: true})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.ErrorContains(t, err, "leadership transferred to node2")
+	assert.Equal(t, raft.Leader, ra.State())
+	assert.Len(t, ra.GetConfiguration().Configuration().Servers, 2)
+}
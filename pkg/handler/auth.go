@@ -0,0 +1,477 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// Capabilities granted by policy rules
+const (
+	CapabilityRead  = "read"
+	CapabilityWrite = "write"
+	CapabilityList  = "list"
+	CapabilityAdmin = "admin"
+)
+
+// RootPolicy grants every capability on every resource. It is built in and
+// cannot be changed or deleted.
+const RootPolicy = "root"
+
+// Resources of cluster operations, checked with CapabilityAdmin for changes
+// and CapabilityRead for status.
+const (
+	ResourceRaft       = "sys/raft"
+	ResourceEncryption = "sys/encryption"
+	ResourceAuth       = "sys/auth"
+	ResourceMetrics    = "sys/metrics"
+)
+
+var (
+	// ErrUnauthenticated is returned for a missing, unknown or expired token.
+	ErrUnauthenticated = errors.New("missing or invalid token")
+	// ErrPermissionDenied is returned when no policy of a token grants the
+	// requested capability.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// KVResource returns the resource path of a key, or of every key under a
+// prefix, in policy rules.
+func KVResource(key string) string {
+	return "kv/" + key
+}
+
+// SecretResource returns the resource path of a secret in policy rules.
+func SecretResource(path string) string {
+	return "secret/" + path
+}
+
+// PolicyRule grants capabilities on every resource starting with Path, such
+// as "kv/app/", "secret/" or "sys/raft". An empty Path matches every resource.
+type PolicyRule struct {
+	Path         string   `json:"path"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Policy is a named set of rules attached to tokens.
+type Policy struct {
+	Name  string       `json:"name"`
+	Rules []PolicyRule `json:"rules"`
+}
+
+// allows reports whether a rule of p grants capability on resource.
+func (p *Policy) allows(resource, capability string) bool {
+	for _, rule := range p.Rules {
+		if !strings.HasPrefix(resource, rule.Path) {
+			continue
+		}
+		for _, c := range rule.Capabilities {
+			if c == capability {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Token is an API token. Its secret is only returned when it is created.
+type Token struct {
+	// Accessor identifies the token in the token API without revealing it.
+	Accessor string   `json:"accessor"`
+	Name     string   `json:"name,omitempty"`
+	Policies []string `json:"policies"`
+	// ExpiresAt is the Unix time at which the token expires, or 0.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// RequestToken represents the payload for creating a token.
+type RequestToken struct {
+	Name     string        `json:"name"`
+	Policies []string      `json:"policies"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+}
+
+// WithAuth makes h require a token for every operation checked through
+// Authenticate and Authorize.
+func (h *Handler) WithAuth() *Handler {
+	h.auth = true
+	return h
+}
+
+// AuthEnabled reports whether operations require a token.
+func (h Handler) AuthEnabled() bool {
+	return h.auth
+}
+
+// Authenticate returns the token whose secret is given. With auth disabled
+// it returns nil and no error.
+func (h Handler) Authenticate(secret string) (*Token, error) {
+	if !h.auth {
+		return nil, nil
+	}
+	if secret == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	token := &Token{}
+	found, err := readJSON(txn, fsm.AuthTokenPrefix+hashToken(secret), token)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token: %s", err.Error())
+	}
+	if !found || (token.ExpiresAt != 0 && time.Now().Unix() >= token.ExpiresAt) {
+		return nil, ErrUnauthenticated
+	}
+	return token, nil
+}
+
+// Authorize checks that a policy of token grants capability on resource.
+// With auth disabled every operation is allowed.
+func (h Handler) Authorize(token *Token, resource, capability string) error {
+	if !h.auth {
+		return nil
+	}
+	if token == nil {
+		return ErrUnauthenticated
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	for _, name := range token.Policies {
+		if name == RootPolicy {
+			return nil
+		}
+		policy := &Policy{}
+		found, err := readJSON(txn, fsm.AuthPolicyPrefix+name, policy)
+		if err != nil {
+			return fmt.Errorf("error reading policy %s: %s", name, err.Error())
+		}
+		if found && policy.allows(resource, capability) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s on %s", ErrPermissionDenied, capability, resource)
+}
+
+// Check authenticates secret and authorizes capability on resource.
+func (h Handler) Check(secret, resource, capability string) (*Token, error) {
+	token, err := h.Authenticate(secret)
+	if err != nil {
+		return nil, err
+	}
+	return token, h.Authorize(token, resource, capability)
+}
+
+// InitRootToken creates a root token the first time auth is initialized in
+// the cluster and returns its secret. Later calls, on any node, return false.
+// This operation must be performed on the Raft leader.
+func (h Handler) InitRootToken() (string, bool, error) {
+	if h.raft.State() != raft.Leader {
+		return "", false, fmt.Errorf("not the leader")
+	}
+
+	secret, payload, err := newTokenPayload(RequestToken{Name: "root", Policies: []string{RootPolicy}})
+	if err != nil {
+		return "", false, err
+	}
+	absent := false
+	resp, err := applyPayload(h.raft, fsm.CommandPayload{
+		Operation: "TXN",
+		Txn: &fsm.Txn{
+			Guards: []fsm.TxnGuard{{Key: fsm.AuthInitializedKey, Exists: &absent}},
+			Then: []fsm.CommandPayload{
+				{Operation: "SET", Key: fsm.AuthInitializedKey, Value: true},
+				payload,
+			},
+		},
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if resp.Error != nil {
+		return "", false, resp.Error
+	}
+	result, ok := resp.Data.(*fsm.TxnResult)
+	if !ok {
+		return "", false, fmt.Errorf("response does not match txn result")
+	}
+	if !result.Succeeded {
+		return "", false, nil
+	}
+	return secret, true, nil
+}
+
+// CreateToken creates a token with the given policies and returns its secret.
+// This operation must be performed on the Raft leader.
+func (h Handler) CreateToken(form RequestToken) (string, *Token, error) {
+	if len(form.Policies) == 0 {
+		return "", nil, fmt.Errorf("policies are empty")
+	}
+	if form.TTL < 0 {
+		return "", nil, fmt.Errorf("ttl must not be negative")
+	}
+	if h.raft.State() != raft.Leader {
+		return "", nil, fmt.Errorf("not the leader")
+	}
+
+	secret, payload, err := newTokenPayload(form)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := h.applyAuth(payload); err != nil {
+		return "", nil, err
+	}
+	return secret, payload.Value.(*Token), nil
+}
+
+// ListTokens returns every token, without their secrets.
+func (h Handler) ListTokens() ([]Token, error) {
+	tokens := []Token{}
+	err := h.scanAuth(fsm.AuthTokenPrefix, func(_ string, val []byte) error {
+		var token Token
+		if err := json.Unmarshal(val, &token); err != nil {
+			return err
+		}
+		tokens = append(tokens, token)
+		return nil
+	})
+	return tokens, err
+}
+
+// GetToken returns the token with the given accessor, or nil.
+func (h Handler) GetToken(accessor string) (*Token, error) {
+	_, token, err := h.findToken(accessor)
+	return token, err
+}
+
+// RevokeToken deletes the token with the given accessor. It returns false if
+// there is none.
+// This operation must be performed on the Raft leader.
+func (h Handler) RevokeToken(accessor string) (bool, error) {
+	if h.raft.State() != raft.Leader {
+		return false, fmt.Errorf("not the leader")
+	}
+	key, token, err := h.findToken(accessor)
+	if err != nil || token == nil {
+		return false, err
+	}
+	return true, h.applyAuth(fsm.CommandPayload{Operation: "DELETE", Key: key})
+}
+
+// PutPolicy creates or replaces a policy.
+// This operation must be performed on the Raft leader.
+func (h Handler) PutPolicy(policy Policy) error {
+	if err := validatePolicyName(policy.Name); err != nil {
+		return err
+	}
+	for _, rule := range policy.Rules {
+		for _, c := range rule.Capabilities {
+			switch c {
+			case CapabilityRead, CapabilityWrite, CapabilityList, CapabilityAdmin:
+			default:
+				return fmt.Errorf("invalid capability %q", c)
+			}
+		}
+	}
+	if h.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader")
+	}
+
+	return h.applyAuth(fsm.CommandPayload{
+		Operation: "SET",
+		Key:       fsm.AuthPolicyPrefix + policy.Name,
+		Value:     policy,
+	})
+}
+
+// GetPolicy returns the named policy, or nil.
+func (h Handler) GetPolicy(name string) (*Policy, error) {
+	if name == RootPolicy {
+		return &Policy{Name: RootPolicy, Rules: []PolicyRule{{
+			Capabilities: []string{CapabilityRead, CapabilityWrite, CapabilityList, CapabilityAdmin},
+		}}}, nil
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	policy := &Policy{}
+	found, err := readJSON(txn, fsm.AuthPolicyPrefix+name, policy)
+	if err != nil || !found {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// ListPolicies returns the names of every policy, including RootPolicy.
+func (h Handler) ListPolicies() ([]string, error) {
+	names := []string{RootPolicy}
+	err := h.scanAuth(fsm.AuthPolicyPrefix, func(key string, _ []byte) error {
+		names = append(names, strings.TrimPrefix(key, fsm.AuthPolicyPrefix))
+		return nil
+	})
+	return names, err
+}
+
+// DeletePolicy deletes the named policy. Tokens keep referring to it but it
+// no longer grants anything.
+// This operation must be performed on the Raft leader.
+func (h Handler) DeletePolicy(name string) error {
+	if err := validatePolicyName(name); err != nil {
+		return err
+	}
+	if h.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader")
+	}
+	return h.applyAuth(fsm.CommandPayload{Operation: "DELETE", Key: fsm.AuthPolicyPrefix + name})
+}
+
+// applyAuth applies a write to the authentication data.
+func (h Handler) applyAuth(payload fsm.CommandPayload) error {
+	resp, err := applyPayload(h.raft, payload)
+	if err != nil {
+		return err
+	}
+	return resp.Error
+}
+
+// findToken returns the key and token with the given accessor, or nil.
+func (h Handler) findToken(accessor string) (string, *Token, error) {
+	var key string
+	var found *Token
+	err := h.scanAuth(fsm.AuthTokenPrefix, func(k string, val []byte) error {
+		var token Token
+		if err := json.Unmarshal(val, &token); err != nil {
+			return err
+		}
+		if token.Accessor == accessor {
+			key, found = k, &token
+		}
+		return nil
+	})
+	return key, found, err
+}
+
+// scanAuth calls fn with every key under prefix and its value.
+func (h Handler) scanAuth(prefix string, fn func(key string, val []byte) error) error {
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key())
+		err := item.Value(func(val []byte) error {
+			return fn(key, val)
+		})
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", key, err.Error())
+		}
+	}
+	return nil
+}
+
+// newTokenPayload generates a token secret and the SET payload that stores
+// the token under its hash.
+func newTokenPayload(form RequestToken) (string, fsm.CommandPayload, error) {
+	secret := make([]byte, 32)
+	accessor := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fsm.CommandPayload{}, err
+	}
+	if _, err := rand.Read(accessor); err != nil {
+		return "", fsm.CommandPayload{}, err
+	}
+
+	token := &Token{
+		Accessor: hex.EncodeToString(accessor),
+		Name:     form.Name,
+		Policies: form.Policies,
+	}
+	payload := fsm.CommandPayload{Operation: "SET", Value: token}
+	if form.TTL > 0 {
+		payload.ExpiresAt = expiresAt(form.TTL)
+		token.ExpiresAt = int64(payload.ExpiresAt)
+	}
+
+	encoded := "bvt." + hex.EncodeToString(secret)
+	payload.Key = fsm.AuthTokenPrefix + hashToken(encoded)
+	return encoded, payload, nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// readJSON decodes the JSON value of key into out and reports whether the
+// key exists.
+func readJSON(txn *badger.Txn, key string, out interface{}) (bool, error) {
+	item, err := txn.Get([]byte(key))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, item.Value(func(val []byte) error {
+		return json.Unmarshal(val, out)
+	})
+}
+
+func validatePolicyName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		return fmt.Errorf("invalid policy name %q", name)
+	}
+	if name == RootPolicy {
+		return fmt.Errorf("policy %s is built in", RootPolicy)
+	}
+	return nil
+}
+
+// AuthorizeTxn checks that token may read every guarded or fetched key of a
+// transaction and write every key it sets or deletes.
+func (h Handler) AuthorizeTxn(token *Token, form RequestTxn) error {
+	for _, guard := range form.Guards {
+		if err := h.Authorize(token, KVResource(guard.Key), CapabilityRead); err != nil {
+			return err
+		}
+	}
+	for _, op := range append(append([]RequestTxnOp{}, form.Then...), form.Else...) {
+		capability := CapabilityWrite
+		if strings.EqualFold(strings.TrimSpace(op.Op), "get") {
+			capability = CapabilityRead
+		}
+		if err := h.Authorize(token, KVResource(op.Key), capability); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuthorizeBatch checks that token may write every key of a batch.
+func (h Handler) AuthorizeBatch(token *Token, ops []RequestBatchOp) error {
+	for _, op := range ops {
+		if err := h.Authorize(token, KVResource(op.Key), CapabilityWrite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+const (
+	// maxBatchOps caps the number of operations in one batch.
+	maxBatchOps = 10000
+	// maxBatchBytes caps the encoded size of a batch. A batch is a single
+	// log entry and the leader ships up to MaxAppendEntries entries in one
+	// AppendEntries RPC, so large batches would make every RPC that carries
+	// them slow to send, persist and apply.
+	maxBatchBytes = 4 << 20
+)
+
+// RequestBatchOp is a set or delete operation of a batch.
+type RequestBatchOp struct {
+	Op    string      `json:"op"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+	// TTL is a duration such as "30s" that expires a set key.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Batch applies many set and delete operations as a single Raft log entry
+// and returns a result per operation. Operations are not conditional and a
+// batch is not a transaction: use Txn to update keys atomically.
+// This operation must be performed on the Raft leader.
+func (h Handler) Batch(_ context.Context, ops []RequestBatchOp) ([]fsm.BatchOpResult, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("batch is empty")
+	}
+	if len(ops) > maxBatchOps {
+		return nil, fmt.Errorf("batch has too many operations (max %d)", maxBatchOps)
+	}
+
+	payloads := make([]fsm.CommandPayload, 0, len(ops))
+	for i, op := range ops {
+		key, err := validateTxnKey(op.Key)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i, err.Error())
+		}
+
+		payload := fsm.CommandPayload{Key: key}
+		switch strings.ToLower(strings.TrimSpace(op.Op)) {
+		case "set":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: value is empty for key %s", i, key)
+			}
+			ttl, err := parseTTL(op.TTL, key)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %s", i, err.Error())
+			}
+			payload.Operation = "SET"
+			payload.Value = op.Value
+			payload.ExpiresAt = expiresAt(ttl)
+		case "delete":
+			payload.Operation = "DELETE"
+		default:
+			return nil, fmt.Errorf("operation %d: invalid batch operation %q", i, op.Op)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	if h.raft.State() != raft.Leader {
+		return nil, fmt.Errorf("not the leader")
+	}
+
+	for i := range payloads {
+		if err := h.seal(&payloads[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(fsm.CommandPayload{
+		Operation: "BATCH",
+		Batch:     payloads,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error preparing batch payload: %s", err.Error())
+	}
+	if len(data) > maxBatchBytes {
+		return nil, fmt.Errorf("batch is %d bytes, larger than the %d byte limit", len(data), maxBatchBytes)
+	}
+
+	applyFuture := h.raft.Apply(data, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return nil, fmt.Errorf("error applying batch in raft cluster: %w", err)
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return nil, fmt.Errorf("response does not match apply response")
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	results, ok := resp.Data.([]fsm.BatchOpResult)
+	if !ok {
+		return nil, fmt.Errorf("response does not match batch results")
+	}
+	return results, nil
+}
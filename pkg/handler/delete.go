@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -15,10 +16,20 @@ import (
 // The operation is applied to the Raft cluster and acknowledged by a quorum.
 // This method must be executed on the Raft leader; otherwise, it returns an error.
 func (h Handler) Delete(key string) error {
+	return h.DeleteWithCAS(key, nil)
+}
+
+// DeleteWithCAS removes data like Delete. When casIndex is set, the key is
+// only removed if its current mod index equals it; otherwise an
+// *fsm.ConflictError is returned.
+func (h Handler) DeleteWithCAS(key string, casIndex *uint64) error {
 	key = strings.TrimSpace(key)
 	if key == "" {
 		return fmt.Errorf("key is empty")
 	}
+	if fsm.IsReservedKey(key) {
+		return fmt.Errorf("key %s is reserved", key)
+	}
 
 	if h.raft.State() != raft.Leader {
 		return fmt.Errorf("not the leader")
@@ -28,6 +39,10 @@ func (h Handler) Delete(key string) error {
 		Operation: "DELETE",
 		Key:       key,
 		Value:     nil,
+		CASIndex:  casIndex,
+	}
+	if h.group != nil {
+		return h.group.submit(context.Background(), payload)
 	}
 
 	data, err := json.Marshal(payload)
@@ -37,13 +52,13 @@ func (h Handler) Delete(key string) error {
 
 	applyFuture := h.raft.Apply(data, 500*time.Millisecond)
 	if err := applyFuture.Error(); err != nil {
-		return fmt.Errorf("error removing data in raft cluster: %s", err.Error())
+		return fmt.Errorf("error removing data in raft cluster: %w", err)
 	}
 
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
 		return fmt.Errorf("error response is not match apply response")
 	}
 
-	return nil
+	return resp.Error
 }
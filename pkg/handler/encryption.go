@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// ErrRotationInProgress is returned by RotateKey while the values sealed with
+// earlier data keys are still being re-encrypted.
+var ErrRotationInProgress = errors.New("key rotation is already in progress")
+
+const (
+	// reencryptPageKeys and reencryptPageBytes bound the values resealed by
+	// one REENCRYPT log entry.
+	reencryptPageKeys  = 128
+	reencryptPageBytes = 1 << 20
+	// reencryptPause leaves room for client writes between pages.
+	reencryptPause = 10 * time.Millisecond
+)
+
+// EncryptionStatus describes encryption at rest on this node.
+type EncryptionStatus struct {
+	Enabled bool `json:"enabled"`
+	// ActiveKey is the id of the data key new values are sealed with.
+	ActiveKey string `json:"active_key,omitempty"`
+	// Rotating reports whether values are being re-encrypted.
+	Rotating bool `json:"rotating"`
+	// Resealed is the number of values re-encrypted by the last rotation
+	// started on this node.
+	Resealed int    `json:"resealed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// encryptionState is shared by the copies of a Handler.
+type encryptionState struct {
+	// keyMu serialises data key creation
+	keyMu sync.Mutex
+
+	mu       sync.Mutex
+	rotating bool
+	resealed int
+	err      error
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// WithKeyring makes h seal every value it writes with the active data key of
+// keyring, creating the first data key when needed, and decrypt values it
+// reads. Close stops a running key rotation.
+func (h *Handler) WithKeyring(keyring *encryption.Keyring) *Handler {
+	h.keyring = keyring
+	h.encryption = &encryptionState{stopCh: make(chan struct{})}
+	return h
+}
+
+// seal replaces the value of a SET payload with its ciphertext under the
+// active data key. It does nothing when encryption is disabled.
+func (h Handler) seal(payload *fsm.CommandPayload) error {
+	return h.sealAs(payload, payload.Key)
+}
+
+// sealAs seals a payload like seal, authenticating name instead of the
+// payload key.
+func (h Handler) sealAs(payload *fsm.CommandPayload, name string) error {
+	if h.keyring == nil || (payload.Value == nil && payload.Data == nil) {
+		return nil
+	}
+
+	keyID, err := h.activeDataKey()
+	if err != nil {
+		return err
+	}
+	plaintext, valueType, err := fsm.EncodeValue(*payload)
+	if err != nil {
+		return err
+	}
+	sealed, err := h.keyring.Seal(keyID, plaintext, []byte(name))
+	if err != nil {
+		return fmt.Errorf("error encrypting value for key %s: %s", payload.Key, err.Error())
+	}
+
+	payload.Value = nil
+	payload.Data = sealed
+	payload.KeyID = keyID
+	payload.Type = valueType
+	return nil
+}
+
+// activeDataKey returns the active data key, loaded into the keyring, and
+// creates it if the cluster has none yet.
+func (h Handler) activeDataKey() (string, error) {
+	keyID, err := h.loadActiveDataKey()
+	if err != nil || keyID != "" {
+		return keyID, err
+	}
+
+	h.encryption.keyMu.Lock()
+	defer h.encryption.keyMu.Unlock()
+
+	// Another write may have created it while we waited
+	if keyID, err = h.loadActiveDataKey(); err != nil || keyID != "" {
+		return keyID, err
+	}
+	return h.createDataKey()
+}
+
+// loadActiveDataKey reads the active data key id from the local replica and
+// loads the key into the keyring. It returns "" if there is none.
+func (h Handler) loadActiveDataKey() (string, error) {
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	keyID, err := fsm.ActiveDataKey(txn)
+	if err != nil {
+		return "", fmt.Errorf("error reading active data key: %s", err.Error())
+	}
+	if keyID == "" {
+		return "", nil
+	}
+	if err := fsm.LoadDataKey(txn, h.keyring, keyID); err != nil {
+		return "", fmt.Errorf("error loading data key: %s", err.Error())
+	}
+	return keyID, nil
+}
+
+// createDataKey generates a data key and replicates it, wrapped by the master
+// key, as the new active key. Callers must hold keyMu.
+func (h Handler) createDataKey() (string, error) {
+	keyID, wrapped, err := h.keyring.NewDataKey()
+	if err != nil {
+		return "", fmt.Errorf("error generating data key: %s", err.Error())
+	}
+
+	resp, err := applyPayload(h.raft, fsm.CommandPayload{
+		Operation: "KEY",
+		Key:       keyID,
+		Data:      wrapped,
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+	return keyID, nil
+}
+
+// RotateKey makes a new data key active and starts re-encrypting the values
+// sealed with earlier keys, or stored before encryption was enabled, in the
+// background. Reads and writes continue meanwhile. It returns the new key id.
+// This operation must be performed on the Raft leader. If leadership is lost
+// the re-encryption stops, and RotateKey can be called on the new leader.
+func (h Handler) RotateKey() (string, error) {
+	if h.keyring == nil {
+		return "", fmt.Errorf("encryption is not enabled")
+	}
+	if h.raft.State() != raft.Leader {
+		return "", fmt.Errorf("not the leader")
+	}
+
+	state := h.encryption
+	state.mu.Lock()
+	if state.rotating {
+		state.mu.Unlock()
+		return "", ErrRotationInProgress
+	}
+	state.rotating = true
+	state.resealed = 0
+	state.err = nil
+	state.mu.Unlock()
+
+	state.keyMu.Lock()
+	keyID, err := h.createDataKey()
+	state.keyMu.Unlock()
+	if err != nil {
+		state.mu.Lock()
+		state.rotating = false
+		state.err = err
+		state.mu.Unlock()
+		return "", err
+	}
+
+	state.wg.Add(1)
+	go h.reencrypt(keyID)
+	return keyID, nil
+}
+
+// reencrypt reseals every value not sealed with keyID, one page per log
+// entry, and records the outcome in the encryption state.
+func (h Handler) reencrypt(keyID string) {
+	state := h.encryption
+	defer state.wg.Done()
+
+	var err error
+	defer func() {
+		state.mu.Lock()
+		state.rotating = false
+		state.err = err
+		state.mu.Unlock()
+	}()
+
+	var cursor []byte
+	for {
+		var payloads []fsm.CommandPayload
+		if payloads, cursor, err = h.reencryptPage(keyID, cursor); err != nil {
+			return
+		}
+
+		if len(payloads) > 0 {
+			var resp *fsm.ApplyResponse
+			resp, err = applyPayload(h.raft, fsm.CommandPayload{
+				Operation: "REENCRYPT",
+				Batch:     payloads,
+			})
+			if err == nil {
+				err = resp.Error
+			}
+			if err != nil {
+				return
+			}
+			resealed, _ := resp.Data.(int)
+			state.mu.Lock()
+			state.resealed += resealed
+			state.mu.Unlock()
+		}
+
+		if cursor == nil {
+			return
+		}
+		select {
+		case <-state.stopCh:
+			err = fmt.Errorf("handler is closed")
+			return
+		case <-time.After(reencryptPause):
+		}
+	}
+}
+
+// reencryptPage reseals with keyID the next values after cursor that are not
+// sealed with it yet. It returns the resealed copies and the cursor of the
+// following page, or nil when the scan is complete.
+func (h Handler) reencryptPage(keyID string, cursor []byte) ([]fsm.CommandPayload, []byte, error) {
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	var payloads []fsm.CommandPayload
+	size := 0
+	for it.Seek(cursor); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key())
+		if cursor != nil && bytes.Equal(item.Key(), cursor) {
+			continue
+		}
+		if fsm.IsReservedKey(key) && !fsm.IsSecretVersionKey(key) {
+			continue
+		}
+		if len(payloads) == reencryptPageKeys || size >= reencryptPageBytes {
+			return payloads, []byte(payloads[len(payloads)-1].Key), nil
+		}
+
+		meta, err := fsm.ReadMeta(txn, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if meta.KeyID == keyID {
+			continue
+		}
+
+		raw, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		plaintext, err := fsm.OpenValue(txn, h.keyring, key, raw, meta)
+		if err != nil {
+			return nil, nil, err
+		}
+		sealed, err := h.keyring.Seal(keyID, plaintext, []byte(fsm.SealingName(key)))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		modIndex := meta.ModIndex
+		payloads = append(payloads, fsm.CommandPayload{
+			Key:      key,
+			Data:     sealed,
+			KeyID:    keyID,
+			CASIndex: &modIndex,
+		})
+		size += len(key) + len(sealed)
+	}
+	return payloads, nil, nil
+}
+
+// EncryptionStatus reports whether values are encrypted, the active data key
+// as seen by the local replica, and the progress of key rotation.
+func (h Handler) EncryptionStatus() (*EncryptionStatus, error) {
+	status := &EncryptionStatus{Enabled: h.keyring != nil}
+	if h.keyring == nil {
+		return status, nil
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+	keyID, err := fsm.ActiveDataKey(txn)
+	if err != nil {
+		return nil, fmt.Errorf("error reading active data key: %s", err.Error())
+	}
+	status.ActiveKey = keyID
+
+	state := h.encryption
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	status.Rotating = state.rotating
+	status.Resealed = state.resealed
+	if state.err != nil {
+		status.Error = state.err.Error()
+	}
+	return status, nil
+}
@@ -1,21 +1,159 @@
 package handler
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// Consistency selects how up to date a read must be.
+type Consistency string
+
+const (
+	// ConsistencyStale reads the local replica. It works on any node but may
+	// return data that a newer leader has already overwritten.
+	ConsistencyStale Consistency = "stale"
+	// ConsistencyLeader reads on the leader after confirming with a quorum
+	// that it is still the leader, so a deposed leader cannot answer.
+	ConsistencyLeader Consistency = "leader"
+	// ConsistencyLinearizable commits a barrier through the Raft log and
+	// reads once every earlier entry has been applied, so the read reflects
+	// every write acknowledged before it started.
+	ConsistencyLinearizable Consistency = "linearizable"
 )
 
+// ParseConsistency converts a query value into a Consistency. An empty
+// string selects ConsistencyStale.
+func ParseConsistency(value string) (Consistency, error) {
+	switch Consistency(strings.ToLower(strings.TrimSpace(value))) {
+	case "", ConsistencyStale:
+		return ConsistencyStale, nil
+	case ConsistencyLeader:
+		return ConsistencyLeader, nil
+	case ConsistencyLinearizable:
+		return ConsistencyLinearizable, nil
+	}
+	return "", fmt.Errorf("invalid consistency %q", value)
+}
+
 // Get fetches data from BadgerDB where the Raft uses to store data.
 // This method can be called on any Raft server, offering eventual consistency on read.
 func (h Handler) Get(key string) (any, error) {
+	return h.GetWithConsistency(key, ConsistencyStale)
+}
+
+// GetWithConsistency fetches data like Get, first making sure the local
+// replica satisfies the requested consistency. ConsistencyLeader and
+// ConsistencyLinearizable must be executed on the Raft leader; otherwise,
+// it returns an error.
+func (h Handler) GetWithConsistency(key string, consistency Consistency) (any, error) {
+	entry, err := h.GetEntry(key, consistency)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// Entry is a stored value together with the Raft indexes that created and
+// last modified it.
+type Entry struct {
+	Key string `json:"key"`
+	// Value is the decoded JSON value, or a []byte if Type is
+	// fsm.ValueTypeBinary.
+	Value       any    `json:"value"`
+	Type        string `json:"type,omitempty"`
+	CreateIndex uint64 `json:"create_index"`
+	ModIndex    uint64 `json:"mod_index"`
+}
+
+// GetEntry fetches a key like GetWithConsistency and also returns its
+// indexes, which can be used for compare-and-swap writes.
+// It returns nil if the key does not exist.
+func (h Handler) GetEntry(key string, consistency Consistency) (*Entry, error) {
 	key = strings.TrimSpace(key)
 	if key == "" {
 		return nil, fmt.Errorf("key is empty")
 	}
+	if fsm.IsReservedKey(key) {
+		return nil, fmt.Errorf("key %s is reserved", key)
+	}
+
+	if err := h.ensureConsistency(consistency); err != nil {
+		return nil, err
+	}
+
+	return h.readEntry(key)
+}
+
+// DefaultReadBarrierTimeout is how long a linearizable read waits for the
+// leader to apply every committed entry, unless WithReadBarrierTimeout sets
+// another limit.
+const DefaultReadBarrierTimeout = 500 * time.Millisecond
+
+// WithReadBarrierTimeout sets how long linearizable reads wait for the
+// leader to apply every committed entry. Zero keeps the default.
+func (h *Handler) WithReadBarrierTimeout(timeout time.Duration) *Handler {
+	h.readBarrierTimeout = timeout
+	return h
+}
+
+// ensureConsistency blocks until a local read would satisfy consistency.
+func (h Handler) ensureConsistency(consistency Consistency) error {
+	switch consistency {
+	case ConsistencyStale:
+		return nil
+	case ConsistencyLeader:
+		if h.raft.State() != raft.Leader {
+			return fmt.Errorf("not the leader")
+		}
+		err := h.raft.VerifyLeader().Error()
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return fmt.Errorf("not the leader")
+		}
+		if err != nil {
+			return fmt.Errorf("error verifying leadership: %s", err.Error())
+		}
+		return nil
+	case ConsistencyLinearizable:
+		if h.raft.State() != raft.Leader {
+			return fmt.Errorf("not the leader")
+		}
+		timeout := h.readBarrierTimeout
+		if timeout <= 0 {
+			timeout = DefaultReadBarrierTimeout
+		}
+		err := h.raft.Barrier(timeout).Error()
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return fmt.Errorf("not the leader")
+		}
+		if err == raft.ErrEnqueueTimeout {
+			return fmt.Errorf("read barrier did not complete within %s; raise raft.readBarrierTimeout on a slow cluster", timeout)
+		}
+		if err != nil {
+			return fmt.Errorf("error waiting for read barrier: %s", err.Error())
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid consistency %q", consistency)
+}
+
+// read fetches and decodes a key from BadgerDB without any validation.
+func (h Handler) read(key string) (any, error) {
+	entry, err := h.readEntry(key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
 
+// readEntry fetches and decodes a key and its indexes from BadgerDB
+// without any validation.
+func (h Handler) readEntry(key string) (*Entry, error) {
 	txn := h.db.NewTransaction(false)
 	defer func() {
 		if err := txn.Commit(); err != nil && err != badger.ErrTxnTooBig {
@@ -40,12 +178,21 @@ func (h Handler) Get(key string) (any, error) {
 		return nil, fmt.Errorf("error retrieving value for key %s: %s", key, err.Error())
 	}
 
-	var data any
-	if len(value) > 0 {
-		if err = json.Unmarshal(value, &data); err != nil {
-			return nil, fmt.Errorf("error unmarshaling data for key %s: %s", key, err.Error())
-		}
+	meta, err := fsm.ReadMeta(txn, key)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving indexes for key %s: %s", key, err.Error())
+	}
+
+	data, err := fsm.ReadValue(txn, h.keyring, key, value, meta)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling data for key %s: %s", key, err.Error())
 	}
 
-	return data, nil
+	return &Entry{
+		Key:         key,
+		Value:       data,
+		Type:        meta.Type,
+		CreateIndex: meta.CreateIndex,
+		ModIndex:    meta.ModIndex,
+	}, nil
 }
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// ErrWriteQueueFull is returned when group commit is enabled and more writes
+// are waiting than the queue can hold.
+var ErrWriteQueueFull = errors.New("write queue is full")
+
+// GroupCommitOptions configures group commit of concurrent writes.
+type GroupCommitOptions struct {
+	// Window is how long a batch waits for more writes after the first one.
+	Window time.Duration
+	// MaxBatch submits a batch as soon as it holds this many writes.
+	MaxBatch int
+	// QueueDepth is how many writes may wait for a batch; further writes
+	// fail with ErrWriteQueueFull.
+	QueueDepth int
+}
+
+// pendingWrite is a write waiting in the group commit queue.
+type pendingWrite struct {
+	payload fsm.CommandPayload
+	done    chan error
+}
+
+// groupCommitter collects concurrent Store and Delete calls and applies them
+// as a single BATCH log entry, so they share one Raft round trip and fsync.
+type groupCommitter struct {
+	raft   RaftNode
+	opts   GroupCommitOptions
+	queue  chan *pendingWrite
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// WithGroupCommit makes h batch its Store and Delete calls. Close stops the
+// batching goroutine.
+func (h *Handler) WithGroupCommit(opts GroupCommitOptions) *Handler {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 128
+	}
+	if opts.QueueDepth <= 0 {
+		opts.QueueDepth = 1024
+	}
+
+	g := &groupCommitter{
+		raft:   h.raft,
+		opts:   opts,
+		queue:  make(chan *pendingWrite, opts.QueueDepth),
+		stopCh: make(chan struct{}),
+	}
+	g.wg.Add(1)
+	go g.run()
+
+	h.group = g
+	return h
+}
+
+// Close stops the group commit goroutine and key rotation, if any. Writes
+// still queued fail.
+func (h *Handler) Close() {
+	if h.encryption != nil {
+		close(h.encryption.stopCh)
+		h.encryption.wg.Wait()
+	}
+	if h.group == nil {
+		return
+	}
+	close(h.group.stopCh)
+	h.group.wg.Wait()
+}
+
+// submit queues payload and waits for the batch holding it to be applied.
+func (g *groupCommitter) submit(ctx context.Context, payload fsm.CommandPayload) error {
+	w := &pendingWrite{payload: payload, done: make(chan error, 1)}
+	select {
+	case g.queue <- w:
+	default:
+		return ErrWriteQueueFull
+	}
+
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		// The write may still be applied; only the caller stops waiting
+		return ctx.Err()
+	case <-g.stopCh:
+		// Let run finish the batch it may be committing, then see whether
+		// it included this write
+		g.wg.Wait()
+		select {
+		case err := <-w.done:
+			return err
+		default:
+			return fmt.Errorf("handler is closed")
+		}
+	}
+}
+
+// run collects queued writes into batches until stopCh is closed.
+func (g *groupCommitter) run() {
+	defer g.wg.Done()
+
+	for {
+		select {
+		case <-g.stopCh:
+			g.drain()
+			return
+		case first := <-g.queue:
+			batch := []*pendingWrite{first}
+			timer := time.NewTimer(g.opts.Window)
+		collect:
+			for len(batch) < g.opts.MaxBatch {
+				select {
+				case w := <-g.queue:
+					batch = append(batch, w)
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+			g.commit(batch)
+		}
+	}
+}
+
+// drain fails every write still in the queue.
+func (g *groupCommitter) drain() {
+	for {
+		select {
+		case w := <-g.queue:
+			w.done <- fmt.Errorf("handler is closed")
+		default:
+			return
+		}
+	}
+}
+
+// batchEnvelopeBytes is room for the fields of a BATCH payload around the
+// writes it holds.
+const batchEnvelopeBytes = 256
+
+// commit applies batch as log entries within the limits of explicit batches
+// and hands each write its result.
+func (g *groupCommitter) commit(batch []*pendingWrite) {
+	for len(batch) > 0 {
+		n := groupSize(batch)
+		g.commitGroup(batch[:n])
+		batch = batch[n:]
+	}
+}
+
+// groupSize returns how many writes from the start of batch fit in one log
+// entry of at most maxBatchOps writes and maxBatchBytes. The first write
+// always fits.
+func groupSize(batch []*pendingWrite) int {
+	size := batchEnvelopeBytes
+	for i, w := range batch {
+		if i == maxBatchOps {
+			return i
+		}
+		data, err := json.Marshal(w.payload)
+		if err == nil {
+			size += len(data) + 1
+		}
+		if i > 0 && size > maxBatchBytes {
+			return i
+		}
+	}
+	return len(batch)
+}
+
+// commitGroup applies writes as one log entry and hands each its result.
+// A single write is applied as is, keeping the log free of one-entry batches.
+func (g *groupCommitter) commitGroup(batch []*pendingWrite) {
+	payload := batch[0].payload
+	if len(batch) > 1 {
+		payload = fsm.CommandPayload{Operation: "BATCH"}
+		for _, w := range batch {
+			payload.Batch = append(payload.Batch, w.payload)
+		}
+	}
+
+	resp, err := applyPayload(g.raft, payload)
+	if err == nil && len(batch) > 1 {
+		results, ok := resp.Data.([]fsm.BatchOpResult)
+		if !ok || len(results) != len(batch) {
+			err = fmt.Errorf("response does not match batch results")
+		} else {
+			for i, w := range batch {
+				w.done <- results[i].Err
+			}
+			return
+		}
+	}
+	if err == nil {
+		err = resp.Error
+	}
+	for _, w := range batch {
+		w.done <- err
+	}
+}
+
+// applyPayload applies payload through Raft and returns the FSM response.
+func applyPayload(r RaftNode, payload fsm.CommandPayload) (*fsm.ApplyResponse, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing payload: %s", err.Error())
+	}
+
+	applyFuture := r.Apply(data, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return nil, fmt.Errorf("error persisting data in raft cluster: %w", err)
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return nil, fmt.Errorf("response does not match apply response")
+	}
+	return resp, nil
+}
+
+// IsLeadershipError reports whether err comes from Raft refusing or losing a
+// write because this node is not, or is no longer, the leader. The write can
+// be retried, on the new leader.
+func IsLeadershipError(err error) bool {
+	return errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) ||
+		errors.Is(err, raft.ErrLeadershipTransferInProgress)
+}
@@ -5,12 +5,18 @@ import (
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
 )
 
 // RaftNode represents the minimal Raft interface needed by Handler
 type RaftNode interface {
 	Apply([]byte, time.Duration) raft.ApplyFuture
 	State() raft.RaftState
+	LeaderWithID() (raft.ServerAddress, raft.ServerID)
+	VerifyLeader() raft.Future
+	Barrier(time.Duration) raft.Future
 }
 
 // DB represents the minimal BadgerDB interface needed by Handler
@@ -19,8 +25,20 @@ type DB interface {
 }
 
 type Handler struct {
-	raft RaftNode
-	db   DB
+	raft       RaftNode
+	db         DB
+	watcher    *fsm.Watcher
+	group      *groupCommitter
+	keyring    *encryption.Keyring
+	encryption *encryptionState
+	// secretVersions is the number of versions kept per secret
+	secretVersions int
+	// auth requires a token for every operation
+	auth bool
+	// readBarrierTimeout bounds how long a linearizable read waits for the
+	// leader to apply what it has committed, or 0 for
+	// DefaultReadBarrierTimeout
+	readBarrierTimeout time.Duration
 }
 
 func NewActionHandler(raft RaftNode, db DB) *Handler {
@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
 	"github.com/subash-0044/beaver-vault/pkg/fsm"
 )
 
@@ -115,6 +120,41 @@ func TestHandlerWithRealRaft(t *testing.T) {
 		assert.Nil(t, value)
 	})
 
+	// Test Store with a TTL
+	t.Run("Store With TTL", func(t *testing.T) {
+		err := h.Store(context.Background(), RequestStore{
+			Key:   "ttl-key",
+			Value: "ttl-value",
+			TTL:   time.Second,
+		})
+		assert.NoError(t, err)
+
+		value, err := h.Get("ttl-key")
+		assert.NoError(t, err)
+		assert.Equal(t, "ttl-value", value)
+
+		// Expiry has one-second granularity
+		time.Sleep(2100 * time.Millisecond)
+		value, err = h.Get("ttl-key")
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+
+		err = h.Store(context.Background(), RequestStore{Key: "ttl-key", Value: "v", TTL: -time.Second})
+		assert.EqualError(t, err, "ttl must not be negative")
+	})
+
+	// Test consistent reads on the leader
+	t.Run("Get With Consistency", func(t *testing.T) {
+		for _, consistency := range []Consistency{ConsistencyStale, ConsistencyLeader, ConsistencyLinearizable} {
+			value, err := h.GetWithConsistency("test-key", consistency)
+			assert.NoError(t, err, consistency)
+			assert.Equal(t, "test-value", value, consistency)
+		}
+
+		_, err := h.GetWithConsistency("test-key", Consistency("bogus"))
+		assert.EqualError(t, err, `invalid consistency "bogus"`)
+	})
+
 	// Test Delete operation
 	t.Run("Delete", func(t *testing.T) {
 		err := h.Delete("test-key")
@@ -177,6 +217,12 @@ func TestHandlerWithRealRaft(t *testing.T) {
 		// Delete should fail on follower
 		err = followerHandler.Delete("replicated-key")
 		assert.EqualError(t, err, "not the leader")
+
+		// Only stale reads can be served by a follower
+		for _, consistency := range []Consistency{ConsistencyLeader, ConsistencyLinearizable} {
+			_, err = followerHandler.GetWithConsistency("replicated-key", consistency)
+			assert.EqualError(t, err, "not the leader")
+		}
 	})
 
 	// Test error cases
@@ -194,9 +240,423 @@ func TestHandlerWithRealRaft(t *testing.T) {
 		err = h.Delete("")
 		assert.EqualError(t, err, "key is empty")
 
+		// Reserved keyspace
+		err = h.Store(context.Background(), RequestStore{
+			Key:   "_beaver/nodes/node1/http",
+			Value: "localhost:1",
+		})
+		assert.EqualError(t, err, "key _beaver/nodes/node1/http is reserved")
+
+		_, err = h.Get("_beaver/nodes/node1/http")
+		assert.EqualError(t, err, "key _beaver/nodes/node1/http is reserved")
+
 		// Non-existent key
 		value, err := h.Get("non-existent")
 		assert.NoError(t, err)
 		assert.Nil(t, value)
 	})
 }
+
+func TestHandlerList(t *testing.T) {
+	raftNode, db, tmpDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer func() { _ = db.Close() }()
+
+	timeout := time.Now().Add(3 * time.Second)
+	for time.Now().Before(timeout) && raftNode.State() != raft.Leader {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, raft.Leader, raftNode.State(), "Node1 should become leader")
+
+	h := NewActionHandler(raftNode, db)
+	for _, key := range []string{"service/a/port", "service/b/port", "service/c/port", "service/d/port", "other"} {
+		assert.NoError(t, h.Store(context.Background(), RequestStore{Key: key, Value: key}))
+	}
+
+	keys := func(resp *ResponseList) []string {
+		var out []string
+		for _, item := range resp.Items {
+			out = append(out, item.Key)
+		}
+		return out
+	}
+
+	t.Run("Prefix", func(t *testing.T) {
+		resp, err := h.List(RequestList{Prefix: "service/"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"service/a/port", "service/b/port", "service/c/port", "service/d/port"}, keys(resp))
+		assert.Equal(t, "service/a/port", resp.Items[0].Value)
+		assert.Empty(t, resp.NextCursor)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		resp, err := h.List(RequestList{Start: "service/b", End: "service/d"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"service/b/port", "service/c/port"}, keys(resp))
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		var all []string
+		req := RequestList{Prefix: "service/", Limit: 3}
+		for {
+			resp, err := h.List(req)
+			assert.NoError(t, err)
+			all = append(all, keys(resp)...)
+			if resp.NextCursor == "" {
+				break
+			}
+			req.Cursor = resp.NextCursor
+		}
+		assert.Equal(t, []string{"service/a/port", "service/b/port", "service/c/port", "service/d/port"}, all)
+	})
+
+	t.Run("Keys Only", func(t *testing.T) {
+		resp, err := h.List(RequestList{Prefix: "service/", KeysOnly: true, Consistency: ConsistencyLinearizable})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Items, 4)
+		assert.Nil(t, resp.Items[0].Value)
+	})
+
+	t.Run("Reserved Keys", func(t *testing.T) {
+		for _, key := range []string{"_a", "_beaver0"} {
+			assert.NoError(t, h.Store(context.Background(), RequestStore{Key: key, Value: key}))
+		}
+		resp, err := h.List(RequestList{End: "service/"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"_a", "_beaver0", "other"}, keys(resp))
+
+		resp, err = h.List(RequestList{Limit: 1})
+		assert.NoError(t, err)
+		resp, err = h.List(RequestList{Limit: 1, Cursor: resp.NextCursor})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"_beaver0"}, keys(resp))
+	})
+
+	t.Run("Error Cases", func(t *testing.T) {
+		_, err := h.List(RequestList{Cursor: "!!!"})
+		assert.EqualError(t, err, "invalid cursor")
+
+		_, err = h.List(RequestList{Limit: -1})
+		assert.EqualError(t, err, "limit must not be negative")
+	})
+}
+
+func TestHandlerGroupCommit(t *testing.T) {
+	raftNode, db, tmpDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer func() { _ = db.Close() }()
+
+	timeout := time.Now().Add(3 * time.Second)
+	for time.Now().Before(timeout) && raftNode.State() != raft.Leader {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, raft.Leader, raftNode.State(), "Node1 should become leader")
+
+	h := NewActionHandler(raftNode, db).WithGroupCommit(GroupCommitOptions{
+		Window:     20 * time.Millisecond,
+		MaxBatch:   16,
+		QueueDepth: 64,
+	})
+	defer h.Close()
+
+	const writers = 32
+	before := raftNode.LastIndex()
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = h.Store(context.Background(), RequestStore{Key: fmt.Sprintf("group-%d", i), Value: i})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		assert.NoError(t, errs[i])
+		value, err := h.Get(fmt.Sprintf("group-%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, float64(i), value)
+	}
+	assert.Less(t, raftNode.LastIndex()-before, uint64(writers), "writes should share log entries")
+
+	t.Run("Conditions Apply Per Write", func(t *testing.T) {
+		err := h.Store(context.Background(), RequestStore{Key: "group-0", Value: "x", Mode: fsm.WriteModeCreate})
+		var conflict *fsm.ConflictError
+		assert.ErrorAs(t, err, &conflict)
+
+		stale := uint64(1)
+		assert.ErrorAs(t, h.DeleteWithCAS("group-1", &stale), &conflict)
+		assert.NoError(t, h.Delete("group-1"))
+	})
+
+	t.Run("Groups Stay Within Batch Limits", func(t *testing.T) {
+		write := func(value string) *pendingWrite {
+			return &pendingWrite{payload: fsm.CommandPayload{Operation: "SET", Key: "k", Value: value}}
+		}
+		large := strings.Repeat("x", 1<<20)
+		batch := []*pendingWrite{write(large), write(large), write(large), write(large), write(large)}
+		assert.Equal(t, 3, groupSize(batch), "a group stops below maxBatchBytes")
+		assert.Equal(t, 1, groupSize([]*pendingWrite{write(strings.Repeat("x", maxBatchBytes))}))
+
+		batch = make([]*pendingWrite, maxBatchOps+5)
+		for i := range batch {
+			batch[i] = write("v")
+		}
+		assert.Equal(t, maxBatchOps, groupSize(batch))
+
+		// Large writes submitted together are all applied
+		var wg sync.WaitGroup
+		errs := make([]error, 5)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = h.Store(context.Background(), RequestStore{Key: fmt.Sprintf("large-%d", i), Value: large})
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+	})
+}
+
+func TestIsLeadershipError(t *testing.T) {
+	assert.True(t, IsLeadershipError(fmt.Errorf("error persisting data in raft cluster: %w", raft.ErrNotLeader)))
+	assert.True(t, IsLeadershipError(fmt.Errorf("error applying batch in raft cluster: %w", raft.ErrLeadershipLost)))
+	assert.False(t, IsLeadershipError(fmt.Errorf("error applying batch in raft cluster: %w", raft.ErrEnqueueTimeout)))
+}
+
+func TestHandlerEncryption(t *testing.T) {
+	raftNode, db, tmpDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer func() { _ = db.Close() }()
+
+	timeout := time.Now().Add(3 * time.Second)
+	for time.Now().Before(timeout) && raftNode.State() != raft.Leader {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, raft.Leader, raftNode.State(), "Node1 should become leader")
+
+	// Values written before encryption is enabled stay readable
+	plain := NewActionHandler(raftNode, db)
+	assert.NoError(t, plain.Store(context.Background(), RequestStore{Key: "enc-old", Value: "before"}))
+	_, err := plain.RotateKey()
+	assert.Error(t, err)
+
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{9}, encryption.KeySize))
+	assert.NoError(t, err)
+	h := NewActionHandler(raftNode, db).WithKeyring(keyring)
+	defer h.Close()
+
+	rawValue := func(key string) ([]byte, fsm.KeyMeta) {
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		item, err := txn.Get([]byte(key))
+		assert.NoError(t, err)
+		raw, err := item.ValueCopy(nil)
+		assert.NoError(t, err)
+		meta, err := fsm.ReadMeta(txn, key)
+		assert.NoError(t, err)
+		return raw, meta
+	}
+
+	assert.NoError(t, h.Store(context.Background(), RequestStore{Key: "enc-a", Value: "secret-a"}))
+	_, err = h.Batch(context.Background(), []RequestBatchOp{{Op: "set", Key: "enc-b", Value: "secret-b"}})
+	assert.NoError(t, err)
+	_, err = h.Txn(context.Background(), RequestTxn{Then: []RequestTxnOp{{Op: "set", Key: "enc-c", Value: "secret-c"}}})
+	assert.NoError(t, err)
+	_, err = h.PutSecret(context.Background(), RequestSecretPut{Path: "enc-secret", Value: "secret-d"})
+	assert.NoError(t, err)
+
+	status, err := h.EncryptionStatus()
+	assert.NoError(t, err)
+	firstKey := status.ActiveKey
+	assert.NotEmpty(t, firstKey)
+
+	for _, key := range []string{"enc-a", "enc-b", "enc-c"} {
+		raw, meta := rawValue(key)
+		assert.NotContains(t, string(raw), "secret")
+		assert.Equal(t, firstKey, meta.KeyID)
+	}
+	value, err := h.Get("enc-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-a", value)
+	value, err = h.Get("enc-old")
+	assert.NoError(t, err)
+	assert.Equal(t, "before", value)
+
+	list, err := h.List(RequestList{Prefix: "enc-"})
+	assert.NoError(t, err)
+	if assert.Len(t, list.Items, 4) {
+		assert.Equal(t, "secret-a", list.Items[0].Value)
+	}
+
+	t.Run("Rotate Key", func(t *testing.T) {
+		_, metaBefore := rawValue("enc-a")
+
+		keyID, err := h.RotateKey()
+		assert.NoError(t, err)
+		assert.NotEqual(t, firstKey, keyID)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if status, _ = h.EncryptionStatus(); !status.Rotating {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.False(t, status.Rotating)
+		assert.Empty(t, status.Error)
+		assert.Equal(t, keyID, status.ActiveKey)
+		assert.Equal(t, 5, status.Resealed)
+
+		for _, key := range []string{"enc-a", "enc-b", "enc-c", "enc-old"} {
+			raw, meta := rawValue(key)
+			assert.Equal(t, keyID, meta.KeyID)
+			assert.NotContains(t, string(raw), "secret")
+		}
+		_, metaAfter := rawValue("enc-a")
+		assert.Equal(t, metaBefore.ModIndex, metaAfter.ModIndex)
+
+		value, err := h.Get("enc-old")
+		assert.NoError(t, err)
+		assert.Equal(t, "before", value)
+
+		secret, err := h.GetSecret("enc-secret", 0, ConsistencyStale)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret-d", secret.Value)
+	})
+}
+
+func TestHandlerAuth(t *testing.T) {
+	raftNode, db, tmpDir, _ := setupTestRaft(t, "node1")
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer func() { _ = db.Close() }()
+
+	timeout := time.Now().Add(3 * time.Second)
+	for time.Now().Before(timeout) && raftNode.State() != raft.Leader {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, raft.Leader, raftNode.State(), "Node1 should become leader")
+
+	// Without auth every operation is allowed
+	open := NewActionHandler(raftNode, db)
+	token, err := open.Authenticate("")
+	assert.NoError(t, err)
+	assert.NoError(t, open.Authorize(token, KVResource("any"), CapabilityWrite))
+
+	h := NewActionHandler(raftNode, db).WithAuth()
+	_, err = h.Authenticate("")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = h.Authenticate("bvt.unknown")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// The root token is created once
+	root, created, err := h.InitRootToken()
+	assert.NoError(t, err)
+	assert.True(t, created)
+	_, created, err = h.InitRootToken()
+	assert.NoError(t, err)
+	assert.False(t, created)
+	rootToken, err := h.Authenticate(root)
+	assert.NoError(t, err)
+	assert.NoError(t, h.Authorize(rootToken, ResourceRaft, CapabilityAdmin))
+
+	// The secret is not stored
+	txn := db.NewTransaction(false)
+	_, err = txn.Get([]byte(fsm.AuthTokenPrefix + root))
+	assert.ErrorIs(t, err, badger.ErrKeyNotFound)
+	txn.Discard()
+
+	assert.Error(t, h.PutPolicy(Policy{Name: RootPolicy}))
+	assert.Error(t, h.PutPolicy(Policy{Name: "bad", Rules: []PolicyRule{{Path: "kv/", Capabilities: []string{"sudo"}}}}))
+	assert.NoError(t, h.PutPolicy(Policy{Name: "app", Rules: []PolicyRule{
+		{Path: "kv/app/", Capabilities: []string{CapabilityRead, CapabilityWrite}},
+		{Path: "secret/app", Capabilities: []string{CapabilityRead}},
+	}}))
+	names, err := h.ListPolicies()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{RootPolicy, "app"}, names)
+
+	secret, created2, err := h.CreateToken(RequestToken{Name: "app", Policies: []string{"app"}})
+	assert.NoError(t, err)
+	token, err = h.Authenticate(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, created2.Accessor, token.Accessor)
+
+	assert.NoError(t, h.Authorize(token, KVResource("app/config"), CapabilityWrite))
+	assert.NoError(t, h.Authorize(token, SecretResource("app-db"), CapabilityRead))
+	assert.ErrorIs(t, h.Authorize(token, SecretResource("app-db"), CapabilityWrite), ErrPermissionDenied)
+	assert.ErrorIs(t, h.Authorize(token, KVResource("other"), CapabilityRead), ErrPermissionDenied)
+	assert.ErrorIs(t, h.Authorize(token, ResourceAuth, CapabilityAdmin), ErrPermissionDenied)
+
+	assert.NoError(t, h.AuthorizeTxn(token, RequestTxn{
+		Guards: []RequestTxnGuard{{Key: "app/a"}},
+		Then:   []RequestTxnOp{{Op: "set", Key: "app/b", Value: 1}},
+	}))
+	assert.ErrorIs(t, h.AuthorizeTxn(token, RequestTxn{
+		Else: []RequestTxnOp{{Op: "get", Key: "other"}},
+	}), ErrPermissionDenied)
+	assert.ErrorIs(t, h.AuthorizeBatch(token, []RequestBatchOp{
+		{Op: "set", Key: "app/a", Value: 1},
+		{Op: "delete", Key: "other"},
+	}), ErrPermissionDenied)
+
+	// Deleting a policy removes its grants from the tokens using it
+	assert.NoError(t, h.DeletePolicy("app"))
+	assert.ErrorIs(t, h.Authorize(token, KVResource("app/config"), CapabilityRead), ErrPermissionDenied)
+
+	tokens, err := h.ListTokens()
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2)
+	found, err := h.RevokeToken(token.Accessor)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	_, err = h.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	found, err = h.RevokeToken(token.Accessor)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// Expired tokens are rejected
+	secret, _, err = h.CreateToken(RequestToken{Policies: []string{RootPolicy}, TTL: time.Second})
+	assert.NoError(t, err)
+	_, err = h.Authenticate(secret)
+	assert.NoError(t, err)
+	time.Sleep(2100 * time.Millisecond)
+	_, err = h.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+// barrierRaft is a leader whose read barriers time out.
+type barrierRaft struct {
+	RaftNode
+	timeout time.Duration
+}
+
+func (r *barrierRaft) State() raft.RaftState { return raft.Leader }
+
+func (r *barrierRaft) Barrier(timeout time.Duration) raft.Future {
+	r.timeout = timeout
+	return errorFuture{raft.ErrEnqueueTimeout}
+}
+
+type errorFuture struct{ err error }
+
+func (f errorFuture) Error() error { return f.err }
+
+func TestHandlerReadBarrierTimeout(t *testing.T) {
+	r := &barrierRaft{}
+	h := NewActionHandler(r, nil)
+
+	err := h.ensureConsistency(ConsistencyLinearizable)
+	assert.EqualError(t, err, "read barrier did not complete within 500ms; raise raft.readBarrierTimeout on a slow cluster")
+	assert.Equal(t, DefaultReadBarrierTimeout, r.timeout)
+
+	h.WithReadBarrierTimeout(2 * time.Second)
+	assert.Error(t, h.ensureConsistency(ConsistencyLinearizable))
+	assert.Equal(t, 2*time.Second, r.timeout)
+}
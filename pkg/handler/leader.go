@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// IsLeader reports whether this node is currently the Raft leader.
+func (h Handler) IsLeader() bool {
+	return h.raft.State() == raft.Leader
+}
+
+// LeaderHTTPAddress returns the HTTP API address published by the current
+// Raft leader, as read from the local replica.
+func (h Handler) LeaderHTTPAddress() (string, error) {
+	_, leaderID := h.raft.LeaderWithID()
+	if leaderID == "" {
+		return "", fmt.Errorf("no known leader")
+	}
+
+	value, err := h.read(fsm.NodeHTTPAddressKey(string(leaderID)))
+	if err != nil {
+		return "", err
+	}
+
+	addr, ok := value.(string)
+	if !ok || addr == "" {
+		return "", fmt.Errorf("leader %s has not published an HTTP address", leaderID)
+	}
+	return addr, nil
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+const (
+	// DefaultListLimit is used when RequestList.Limit is zero.
+	DefaultListLimit = 100
+	// MaxListLimit caps the number of keys returned by one List call.
+	MaxListLimit = 1000
+)
+
+// RequestList represents the parameters of a prefix or range scan.
+type RequestList struct {
+	// Prefix restricts the scan to keys starting with it.
+	Prefix string
+	// Start is the first key to return (inclusive).
+	Start string
+	// End stops the scan before this key (exclusive). Empty means no bound.
+	End string
+	// Limit is the maximum number of keys to return.
+	Limit int
+	// Cursor resumes a previous scan from its NextCursor.
+	Cursor string
+	// KeysOnly skips reading values.
+	KeysOnly bool
+	// Consistency selects how up to date the scan must be.
+	Consistency Consistency
+}
+
+// KeyValue is a single entry returned by List.
+// Binary values are returned as []byte, which JSON encodes as base64.
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value any    `json:"value,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// ResponseList is a page of scan results.
+type ResponseList struct {
+	Items []KeyValue `json:"items"`
+	// NextCursor is set when more keys remain; pass it back as
+	// RequestList.Cursor to fetch the next page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// List scans keys in BadgerDB by prefix and/or range, in key order.
+// Like Get, it can be called on any Raft server for stale reads.
+func (h Handler) List(form RequestList) (*ResponseList, error) {
+	if form.Limit < 0 {
+		return nil, fmt.Errorf("limit must not be negative")
+	}
+	if form.Limit == 0 {
+		form.Limit = DefaultListLimit
+	}
+	if form.Limit > MaxListLimit {
+		form.Limit = MaxListLimit
+	}
+
+	seek := []byte(form.Start)
+	if form.Prefix > form.Start {
+		seek = []byte(form.Prefix)
+	}
+
+	var after []byte
+	if form.Cursor != "" {
+		var err error
+		after, err = base64.RawURLEncoding.DecodeString(form.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if bytes.Compare(after, seek) >= 0 {
+			seek = after
+		}
+	}
+
+	if form.Consistency == "" {
+		form.Consistency = ConsistencyStale
+	}
+	if err := h.ensureConsistency(form.Consistency); err != nil {
+		return nil, err
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = !form.KeysOnly
+	opts.Prefix = []byte(form.Prefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	resp := &ResponseList{Items: []KeyValue{}}
+	for it.Seek(seek); it.ValidForPrefix(opts.Prefix); it.Next() {
+		if fsm.IsReservedKey(string(it.Item().Key())) {
+			// The reserved keyspace holds metadata for every key, so it is
+			// skipped in one step rather than key by key
+			if it.Seek([]byte(fsm.ReservedKeyEnd)); !it.ValidForPrefix(opts.Prefix) {
+				break
+			}
+		}
+		item := it.Item()
+		key := item.Key()
+
+		if after != nil && bytes.Equal(key, after) {
+			continue
+		}
+		if form.End != "" && bytes.Compare(key, []byte(form.End)) >= 0 {
+			break
+		}
+		if len(resp.Items) == form.Limit {
+			resp.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(resp.Items[len(resp.Items)-1].Key))
+			break
+		}
+
+		kv := KeyValue{Key: string(key)}
+		if !form.KeysOnly {
+			meta, err := fsm.ReadMeta(txn, kv.Key)
+			if err != nil {
+				return nil, fmt.Errorf("error retrieving indexes for key %s: %s", kv.Key, err.Error())
+			}
+			kv.Type = meta.Type
+			err = item.Value(func(val []byte) error {
+				kv.Value, err = fsm.ReadValue(txn, h.keyring, kv.Key, val, meta)
+				return err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error retrieving value for key %s: %s", kv.Key, err.Error())
+			}
+		}
+		resp.Items = append(resp.Items, kv)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// RequestSecretPut represents the payload for writing a new secret version.
+type RequestSecretPut struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	// CAS makes the write conditional on the current version of the secret.
+	// Zero means the secret must not exist yet.
+	CAS *uint64 `json:"cas,omitempty"`
+}
+
+// Secret is one version of a secret. Value is nil when the version is
+// deleted or destroyed.
+type Secret struct {
+	Path        string `json:"path"`
+	Version     uint64 `json:"version"`
+	Value       any    `json:"value,omitempty"`
+	CreateIndex uint64 `json:"create_index"`
+	DeleteIndex uint64 `json:"delete_index,omitempty"`
+	Destroyed   bool   `json:"destroyed,omitempty"`
+}
+
+// WithSecretVersions sets the number of versions kept per secret. Zero keeps
+// fsm.DefaultSecretVersions.
+func (h *Handler) WithSecretVersions(maxVersions int) *Handler {
+	h.secretVersions = maxVersions
+	return h
+}
+
+// PutSecret writes form.Value as a new version of the secret at form.Path and
+// returns the version number. Versions beyond the configured limit are
+// destroyed, oldest first.
+// This operation must be performed on the Raft leader.
+func (h Handler) PutSecret(_ context.Context, form RequestSecretPut) (uint64, error) {
+	path, err := validateSecretPath(form.Path)
+	if err != nil {
+		return 0, err
+	}
+	if form.Value == nil {
+		return 0, fmt.Errorf("value is empty")
+	}
+
+	if h.raft.State() != raft.Leader {
+		return 0, fmt.Errorf("not the leader")
+	}
+
+	payload := fsm.CommandPayload{
+		Operation: "SECRET_PUT",
+		Key:       path,
+		Value:     form.Value,
+		CASIndex:  form.CAS,
+		Secret:    &fsm.SecretOp{MaxVersions: h.secretVersions},
+	}
+	if err := h.sealAs(&payload, fsm.SecretSealingName(path)); err != nil {
+		return 0, err
+	}
+
+	resp, err := applyPayload(h.raft, payload)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, resp.Error
+	}
+
+	version, ok := resp.Data.(uint64)
+	if !ok {
+		return 0, fmt.Errorf("response does not match secret version")
+	}
+	return version, nil
+}
+
+// GetSecret reads a version of the secret at path, or its current version if
+// version is zero. It returns nil if the secret or version does not exist.
+func (h Handler) GetSecret(path string, version uint64, consistency Consistency) (*Secret, error) {
+	path, err := validateSecretPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ensureConsistency(consistency); err != nil {
+		return nil, err
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	meta, err := fsm.ReadSecretMetadata(txn, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret %s: %s", path, err.Error())
+	}
+	if meta == nil {
+		return nil, nil
+	}
+	if version == 0 {
+		version = meta.CurrentVersion
+	}
+	state, ok := meta.Versions[version]
+	if !ok {
+		return nil, nil
+	}
+
+	secret := &Secret{
+		Path:        path,
+		Version:     version,
+		CreateIndex: state.CreateIndex,
+		DeleteIndex: state.DeleteIndex,
+		Destroyed:   state.Destroyed,
+	}
+	if state.DeleteIndex != 0 || state.Destroyed {
+		return secret, nil
+	}
+	if secret.Value, err = fsm.ReadSecretVersion(txn, h.keyring, path, version); err != nil {
+		return nil, fmt.Errorf("error reading version %d of secret %s: %s", version, path, err.Error())
+	}
+	return secret, nil
+}
+
+// SecretMetadata returns the metadata of the secret at path, or nil if it
+// does not exist.
+func (h Handler) SecretMetadata(path string, consistency Consistency) (*fsm.SecretMetadata, error) {
+	path, err := validateSecretPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ensureConsistency(consistency); err != nil {
+		return nil, err
+	}
+
+	txn := h.db.NewTransaction(false)
+	defer txn.Discard()
+
+	meta, err := fsm.ReadSecretMetadata(txn, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret %s: %s", path, err.Error())
+	}
+	return meta, nil
+}
+
+// DeleteSecret soft deletes versions of the secret at path, or its current
+// version if none is given. Deleted versions can be restored with
+// UndeleteSecret until they are destroyed.
+// This operation must be performed on the Raft leader.
+func (h Handler) DeleteSecret(path string, versions []uint64) error {
+	return h.applySecret("SECRET_DELETE", path, versions)
+}
+
+// UndeleteSecret restores soft deleted versions of the secret at path.
+// This operation must be performed on the Raft leader.
+func (h Handler) UndeleteSecret(path string, versions []uint64) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("versions are empty")
+	}
+	return h.applySecret("SECRET_UNDELETE", path, versions)
+}
+
+// DestroySecret permanently removes the values of versions of the secret at
+// path. Their metadata is kept, marked as destroyed.
+// This operation must be performed on the Raft leader.
+func (h Handler) DestroySecret(path string, versions []uint64) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("versions are empty")
+	}
+	return h.applySecret("SECRET_DESTROY", path, versions)
+}
+
+// PurgeSecret permanently removes the secret at path with every version and
+// its metadata.
+// This operation must be performed on the Raft leader.
+func (h Handler) PurgeSecret(path string) error {
+	return h.applySecret("SECRET_PURGE", path, nil)
+}
+
+// applySecret applies a secret operation that changes version states.
+func (h Handler) applySecret(op, path string, versions []uint64) error {
+	path, err := validateSecretPath(path)
+	if err != nil {
+		return err
+	}
+
+	if h.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader")
+	}
+
+	resp, err := applyPayload(h.raft, fsm.CommandPayload{
+		Operation: op,
+		Key:       path,
+		Secret:    &fsm.SecretOp{Versions: versions},
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Error
+}
+
+func validateSecretPath(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", fmt.Errorf("secret path is empty")
+	}
+	return path, nil
+}
@@ -16,16 +16,43 @@ import (
 type RequestStore struct {
 	Key   string      `json:"key"`
 	Value interface{} `json:"value"`
+	// Data stores raw bytes as a binary value in place of Value.
+	Data []byte `json:"data,omitempty"`
+	// TTL expires the key after the given duration. Zero keeps it forever.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Mode restricts the write to fsm.WriteModeCreate or fsm.WriteModeUpdate.
+	Mode string `json:"mode,omitempty"`
+	// CASIndex makes the write conditional on the key's current mod index.
+	// Zero means the key must not exist yet.
+	CASIndex *uint64 `json:"cas_index,omitempty"`
 }
 
 // Store handles saving data to the Raft cluster.
 // It invokes raft.Apply to store the data across the cluster with acknowledgment from a quorum.
 // This operation must be performed on the Raft leader.
-func (h Handler) Store(_ context.Context, form RequestStore) error {
+// With group commit enabled, concurrent calls share a single log entry.
+func (h Handler) Store(ctx context.Context, form RequestStore) error {
 	form.Key = strings.TrimSpace(form.Key)
 	if form.Key == "" {
 		return fmt.Errorf("key is empty")
 	}
+	if fsm.IsReservedKey(form.Key) {
+		return fmt.Errorf("key %s is reserved", form.Key)
+	}
+
+	if form.Data != nil && form.Value != nil {
+		return fmt.Errorf("value and data cannot both be set")
+	}
+	if form.Data != nil && len(form.Data) == 0 {
+		return fmt.Errorf("value is empty")
+	}
+
+	if form.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if form.Mode != "" && form.Mode != fsm.WriteModeCreate && form.Mode != fsm.WriteModeUpdate {
+		return fmt.Errorf("invalid mode %q", form.Mode)
+	}
 
 	if h.raft.State() != raft.Leader {
 		return fmt.Errorf("not the leader")
@@ -35,6 +62,16 @@ func (h Handler) Store(_ context.Context, form RequestStore) error {
 		Operation: "SET",
 		Key:       form.Key,
 		Value:     form.Value,
+		Data:      form.Data,
+		ExpiresAt: expiresAt(form.TTL),
+		Mode:      form.Mode,
+		CASIndex:  form.CASIndex,
+	}
+	if err := h.seal(&payload); err != nil {
+		return err
+	}
+	if h.group != nil {
+		return h.group.submit(ctx, payload)
 	}
 
 	data, err := json.Marshal(payload)
@@ -44,13 +81,26 @@ func (h Handler) Store(_ context.Context, form RequestStore) error {
 
 	applyFuture := h.raft.Apply(data, 500*time.Millisecond)
 	if err := applyFuture.Error(); err != nil {
-		return fmt.Errorf("error persisting data in raft cluster: %s", err.Error())
+		return fmt.Errorf("error persisting data in raft cluster: %w", err)
 	}
 
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
 		return fmt.Errorf("response does not match apply response")
 	}
 
-	return nil
+	return resp.Error
+}
+
+// expiresAt converts a TTL into the absolute expiry carried in the Raft log.
+// Badger expires keys at one-second granularity, so the TTL is rounded up.
+func expiresAt(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	deadline := time.Now().Add(ttl)
+	if deadline.Truncate(time.Second).Equal(deadline) {
+		return uint64(deadline.Unix())
+	}
+	return uint64(deadline.Unix()) + 1
 }
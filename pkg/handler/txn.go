@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// maxTxnOps caps the number of guards and operations in one transaction,
+// keeping a single Raft log entry (and Badger transaction) bounded.
+const maxTxnOps = 128
+
+// RequestTxnGuard is a condition on one key. Every field that is set must hold.
+type RequestTxnGuard struct {
+	Key string `json:"key"`
+	// Exists requires the key to exist (true) or to be absent (false).
+	Exists *bool `json:"exists,omitempty"`
+	// Value requires the key's current value to equal it.
+	Value interface{} `json:"value,omitempty"`
+	// ModIndex requires the key's current mod index to equal it.
+	ModIndex *uint64 `json:"mod_index,omitempty"`
+}
+
+// RequestTxnOp is a set, delete or get operation of a transaction.
+type RequestTxnOp struct {
+	Op    string      `json:"op"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+	// TTL is a duration such as "30s" that expires a set key.
+	TTL      string  `json:"ttl,omitempty"`
+	Mode     string  `json:"mode,omitempty"`
+	CASIndex *uint64 `json:"cas_index,omitempty"`
+}
+
+// RequestTxn represents an atomic multi-key transaction. When every guard
+// holds the Then operations are applied, otherwise the Else operations are.
+type RequestTxn struct {
+	Guards []RequestTxnGuard `json:"guards"`
+	Then   []RequestTxnOp    `json:"then"`
+	Else   []RequestTxnOp    `json:"else"`
+}
+
+// Txn applies a transaction to the Raft cluster as a single log entry, so
+// its operations are applied atomically on every replica.
+// This operation must be performed on the Raft leader.
+func (h Handler) Txn(_ context.Context, form RequestTxn) (*fsm.TxnResult, error) {
+	if len(form.Guards)+len(form.Then)+len(form.Else) > maxTxnOps {
+		return nil, fmt.Errorf("txn has too many guards and operations (max %d)", maxTxnOps)
+	}
+
+	txn := &fsm.Txn{}
+	for _, guard := range form.Guards {
+		key, err := validateTxnKey(guard.Key)
+		if err != nil {
+			return nil, err
+		}
+		txn.Guards = append(txn.Guards, fsm.TxnGuard{
+			Key:      key,
+			Exists:   guard.Exists,
+			Value:    guard.Value,
+			ModIndex: guard.ModIndex,
+		})
+	}
+
+	var err error
+	if txn.Then, err = txnOps(form.Then); err != nil {
+		return nil, err
+	}
+	if txn.Else, err = txnOps(form.Else); err != nil {
+		return nil, err
+	}
+
+	if h.raft.State() != raft.Leader {
+		return nil, fmt.Errorf("not the leader")
+	}
+
+	for _, ops := range [][]fsm.CommandPayload{txn.Then, txn.Else} {
+		for i := range ops {
+			if err := h.seal(&ops[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := json.Marshal(fsm.CommandPayload{
+		Operation: "TXN",
+		Txn:       txn,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error preparing txn payload: %s", err.Error())
+	}
+
+	applyFuture := h.raft.Apply(data, 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return nil, fmt.Errorf("error applying txn in raft cluster: %w", err)
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return nil, fmt.Errorf("response does not match apply response")
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	result, ok := resp.Data.(*fsm.TxnResult)
+	if !ok {
+		return nil, fmt.Errorf("response does not match txn result")
+	}
+	return result, nil
+}
+
+// txnOps validates transaction operations and converts them to payloads.
+func txnOps(ops []RequestTxnOp) ([]fsm.CommandPayload, error) {
+	payloads := make([]fsm.CommandPayload, 0, len(ops))
+	for _, op := range ops {
+		key, err := validateTxnKey(op.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		payload := fsm.CommandPayload{Key: key}
+		switch strings.ToLower(strings.TrimSpace(op.Op)) {
+		case "set":
+			if op.Value == nil {
+				return nil, fmt.Errorf("value is empty for key %s", key)
+			}
+			if op.Mode != "" && op.Mode != fsm.WriteModeCreate && op.Mode != fsm.WriteModeUpdate {
+				return nil, fmt.Errorf("invalid mode %q", op.Mode)
+			}
+			ttl, err := parseTTL(op.TTL, key)
+			if err != nil {
+				return nil, err
+			}
+			payload.Operation = "SET"
+			payload.Value = op.Value
+			payload.ExpiresAt = expiresAt(ttl)
+			payload.Mode = op.Mode
+			payload.CASIndex = op.CASIndex
+		case "delete":
+			payload.Operation = "DELETE"
+			payload.CASIndex = op.CASIndex
+		case "get":
+			payload.Operation = "GET"
+		default:
+			return nil, fmt.Errorf("invalid txn operation %q", op.Op)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// parseTTL parses the ttl of an operation on key, such as "30s". An empty
+// string means no expiry.
+func parseTTL(raw, key string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid ttl %q for key %s", raw, key)
+	}
+	return ttl, nil
+}
+
+func validateTxnKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("key is empty")
+	}
+	if fsm.IsReservedKey(key) {
+		return "", fmt.Errorf("key %s is reserved", key)
+	}
+	return key, nil
+}
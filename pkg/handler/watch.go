@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// RequestWatch selects the keys to watch: a single Key or every key under
+// Prefix. AfterIndex resumes a previous watch after the last index it saw.
+type RequestWatch struct {
+	Key        string
+	Prefix     string
+	AfterIndex uint64
+}
+
+// WithWatcher makes h serve watches from watcher, which must be the one fed
+// by the node's FSM.
+func (h *Handler) WithWatcher(watcher *fsm.Watcher) *Handler {
+	h.watcher = watcher
+	return h
+}
+
+// Watch subscribes to changes of the requested keys as they are applied on
+// this node. It can be called on any Raft server. The caller must Close the
+// subscription.
+func (h Handler) Watch(form RequestWatch) (*fsm.Subscription, error) {
+	if h.watcher == nil {
+		return nil, fmt.Errorf("watch is not enabled")
+	}
+
+	key := strings.TrimSpace(form.Key)
+	if key == "" && form.Prefix == "" {
+		return nil, fmt.Errorf("key or prefix is required")
+	}
+	if key != "" && form.Prefix != "" {
+		return nil, fmt.Errorf("key and prefix cannot be combined")
+	}
+	if fsm.IsReservedKey(key) || fsm.IsReservedKey(form.Prefix) {
+		return nil, fmt.Errorf("key %s is reserved", key+form.Prefix)
+	}
+
+	match := func(k string) bool {
+		if key != "" {
+			return k == key
+		}
+		return strings.HasPrefix(k, form.Prefix) && !fsm.IsReservedKey(k)
+	}
+	return h.watcher.Subscribe(match, form.AfterIndex)
+}
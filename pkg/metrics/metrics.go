@@ -0,0 +1,163 @@
+// Package metrics collects the Prometheus metrics of a node: Raft state, FSM
+// operations, HTTP requests, Badger sizes and the go-metrics that
+// hashicorp/raft emits.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	gometrics "github.com/hashicorp/go-metrics/compat"
+	gometricsprom "github.com/hashicorp/go-metrics/compat/prometheus"
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "beaver"
+
+// Metrics holds the collectors of a node and serves them from its own
+// registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	fsmOperations *prometheus.CounterVec
+	fsmApply      *prometheus.HistogramVec
+	httpRequests  *prometheus.HistogramVec
+	leaderChanges prometheus.Counter
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates a Metrics with the FSM and HTTP collectors and the Go runtime
+// and process collectors registered.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		fsmOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "fsm",
+			Name:      "operations_total",
+			Help:      "Commands applied by the FSM, by operation and result.",
+		}, []string{"operation", "result"}),
+		fsmApply: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "fsm",
+			Name:      "apply_duration_seconds",
+			Help:      "Time taken by the FSM to apply a command, by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"operation"}),
+		httpRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests, by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		leaderChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "raft",
+			Name:      "leader_changes_total",
+			Help:      "Leader changes observed by this node.",
+		}),
+		stopCh: make(chan struct{}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.fsmOperations,
+		m.fsmApply,
+		m.httpRequests,
+		m.leaderChanges,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveApply records a command applied by the FSM. It matches
+// fsm.Options.OnApply.
+func (m *Metrics) ObserveApply(operation string, err error, took time.Duration) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.fsmOperations.WithLabelValues(operation, result).Inc()
+	m.fsmApply.WithLabelValues(operation).Observe(took.Seconds())
+}
+
+// ObserveHTTP records a served HTTP request. route is the route pattern,
+// not the request path, to keep the number of series bounded.
+func (m *Metrics) ObserveHTTP(method, route string, status int, took time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Observe(took.Seconds())
+}
+
+// RegisterRaft exports the state and indexes of r and counts its leader
+// changes until Close.
+func (m *Metrics) RegisterRaft(r *raft.Raft) {
+	m.registry.MustRegister(newRaftCollector(r))
+
+	observations := make(chan raft.Observation, 16)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	})
+	r.RegisterObserver(observer)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer r.DeregisterObserver(observer)
+		for {
+			select {
+			case <-observations:
+				m.leaderChanges.Inc()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// RegisterBadger exports the LSM and value log sizes of db, labelled with
+// store.
+func (m *Metrics) RegisterBadger(store string, db *badger.DB) {
+	m.registry.MustRegister(newBadgerCollector(store, db))
+}
+
+// BridgeGoMetrics sends the go-metrics emitted by hashicorp/raft, such as
+// commit and apply timings, to the registry. It replaces the process-wide
+// go-metrics sink.
+func (m *Metrics) BridgeGoMetrics() error {
+	sink, err := gometricsprom.NewPrometheusSinkFrom(gometricsprom.PrometheusOpts{
+		Registerer: m.registry,
+		Expiration: time.Minute,
+	})
+	if err != nil {
+		return err
+	}
+	config := gometrics.DefaultConfig(namespace)
+	config.EnableHostname = false
+	config.EnableRuntimeMetrics = false
+	_, err = gometrics.NewGlobal(config, sink)
+	return err
+}
+
+// Close stops counting leader changes.
+func (m *Metrics) Close() {
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+	m.wg.Wait()
+}
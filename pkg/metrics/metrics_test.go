@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns the text exposition of m.
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	config := raft.DefaultConfig()
+	config.LocalID = "node1"
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	_, transport := raft.NewInmemTransport("")
+	store := raft.NewInmemStore()
+	ra, err := raft.NewRaft(config, &raft.MockFSM{}, store, store, raft.NewInmemSnapshotStore(), transport)
+	require.NoError(t, err)
+	defer func() { _ = ra.Shutdown().Error() }()
+
+	m := New()
+	defer m.Close()
+	require.NoError(t, m.BridgeGoMetrics())
+	m.RegisterRaft(ra)
+	m.RegisterBadger("data", db)
+
+	ra.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{ID: config.LocalID, Address: transport.LocalAddr()}}})
+	timeout := time.Now().Add(3 * time.Second)
+	for time.Now().Before(timeout) && ra.State() != raft.Leader {
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Equal(t, raft.Leader, ra.State())
+	require.NoError(t, ra.Apply([]byte("x"), time.Second).Error())
+
+	m.ObserveApply("SET", nil, time.Millisecond)
+	m.ObserveApply("SET", errors.New("conflict"), time.Millisecond)
+	m.ObserveHTTP("GET", "/api/v1/kv/:key", 404, 2*time.Millisecond)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `beaver_raft_state{state="Leader"} 1`)
+	assert.Contains(t, body, `beaver_raft_state{state="Follower"} 0`)
+	assert.Contains(t, body, "beaver_raft_term 2")
+	assert.Contains(t, body, "beaver_raft_applied_index")
+	assert.Contains(t, body, "beaver_raft_leader_changes_total 1")
+	assert.Contains(t, body, `beaver_fsm_operations_total{operation="SET",result="ok"} 1`)
+	assert.Contains(t, body, `beaver_fsm_operations_total{operation="SET",result="error"} 1`)
+	assert.Contains(t, body, `beaver_fsm_apply_duration_seconds_count{operation="SET"} 2`)
+	assert.Contains(t, body, `beaver_http_request_duration_seconds_count{method="GET",route="/api/v1/kv/:key",status="404"} 1`)
+	assert.Contains(t, body, `beaver_badger_lsm_size_bytes{store="data"}`)
+	assert.Contains(t, body, `beaver_badger_vlog_size_bytes{store="data"}`)
+	// Bridged from hashicorp/raft
+	assert.Contains(t, body, "beaver_raft_commitTime")
+}
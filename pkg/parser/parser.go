@@ -14,6 +14,7 @@ type JSONValue struct {
 type Store interface {
 	Get(key []byte) ([]byte, error)
 	Put(key, value []byte) error
+	PutWithExpiry(key, value []byte, expiresAt uint64) error
 	Delete(key []byte) error
 }
 
@@ -60,6 +61,12 @@ func (p *Parser) Get(key string) (*JSONValue, error) {
 
 // Put marshals and stores a JSON value for a given key
 func (p *Parser) Put(key string, value any) error {
+	return p.PutWithExpiry(key, value, 0)
+}
+
+// PutWithExpiry marshals and stores a JSON value that expires at the given
+// Unix time (in seconds). An expiresAt of zero never expires.
+func (p *Parser) PutWithExpiry(key string, value any, expiresAt uint64) error {
 	if len(key) == 0 {
 		return fmt.Errorf("key cannot be empty")
 	}
@@ -73,7 +80,10 @@ func (p *Parser) Put(key string, value any) error {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	return p.store.Put([]byte(key), data)
+	if expiresAt == 0 {
+		return p.store.Put([]byte(key), data)
+	}
+	return p.store.PutWithExpiry([]byte(key), data, expiresAt)
 }
 
 // Delete removes a key-value pair
@@ -36,6 +36,10 @@ func (m *mockStore) Put(key, value []byte) error {
 	return nil
 }
 
+func (m *mockStore) PutWithExpiry(key, value []byte, _ uint64) error {
+	return m.Put(key, value)
+}
+
 func (m *mockStore) Delete(key []byte) error {
 	if m.delErr != nil {
 		return m.delErr
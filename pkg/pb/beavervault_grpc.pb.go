@@ -0,0 +1,503 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: beavervault.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	BeaverVault_Get_FullMethodName     = "/beavervault.v1.BeaverVault/Get"
+	BeaverVault_Put_FullMethodName     = "/beavervault.v1.BeaverVault/Put"
+	BeaverVault_Delete_FullMethodName  = "/beavervault.v1.BeaverVault/Delete"
+	BeaverVault_Watch_FullMethodName   = "/beavervault.v1.BeaverVault/Watch"
+	BeaverVault_Join_FullMethodName    = "/beavervault.v1.BeaverVault/Join"
+	BeaverVault_Drop_FullMethodName    = "/beavervault.v1.BeaverVault/Drop"
+	BeaverVault_Promote_FullMethodName = "/beavervault.v1.BeaverVault/Promote"
+	BeaverVault_Demote_FullMethodName  = "/beavervault.v1.BeaverVault/Demote"
+	BeaverVault_Stats_FullMethodName   = "/beavervault.v1.BeaverVault/Stats"
+	BeaverVault_Members_FullMethodName = "/beavervault.v1.BeaverVault/Members"
+)
+
+// BeaverVaultClient is the client API for BeaverVault service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// BeaverVault mirrors the HTTP KV and cluster endpoints.
+type BeaverVaultClient interface {
+	// Get reads a key. See GetRequest.consistency for read guarantees.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Put stores a JSON value. Must be sent to the leader.
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Delete removes a key. Must be sent to the leader.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Watch streams changes of a key or prefix as they are applied on the
+	// node serving the call. Any node can serve it.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error)
+	// Join adds a node to the Raft cluster. Must be sent to the leader.
+	Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*JoinResponse, error)
+	// Drop removes a node from the Raft cluster. Must be sent to the leader.
+	// A leader asked to drop itself transfers leadership instead and answers
+	// UNAVAILABLE naming the new leader, to which the drop has to be sent
+	// again.
+	Drop(ctx context.Context, in *DropRequest, opts ...grpc.CallOption) (*DropResponse, error)
+	// Promote makes a non-voter a voter. Must be sent to the leader.
+	Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*PromoteResponse, error)
+	// Demote makes a voter a non-voter. Like Drop, it keeps the minimum
+	// voters unless forced. Must be sent to the leader.
+	Demote(ctx context.Context, in *DemoteRequest, opts ...grpc.CallOption) (*DemoteResponse, error)
+	// Stats returns the local node's Raft statistics.
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	// Members lists the servers of the Raft cluster. The leader also reports
+	// the replication progress of each follower.
+	Members(ctx context.Context, in *MembersRequest, opts ...grpc.CallOption) (*MembersResponse, error)
+}
+
+type beaverVaultClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBeaverVaultClient(cc grpc.ClientConnInterface) BeaverVaultClient {
+	return &beaverVaultClient{cc}
+}
+
+func (c *beaverVaultClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Put_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BeaverVault_ServiceDesc.Streams[0], BeaverVault_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, WatchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BeaverVault_WatchClient = grpc.ServerStreamingClient[WatchEvent]
+
+func (c *beaverVaultClient) Join(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*JoinResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JoinResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Join_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Drop(ctx context.Context, in *DropRequest, opts ...grpc.CallOption) (*DropResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DropResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Drop_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*PromoteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PromoteResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Promote_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Demote(ctx context.Context, in *DemoteRequest, opts ...grpc.CallOption) (*DemoteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DemoteResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Demote_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Stats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *beaverVaultClient) Members(ctx context.Context, in *MembersRequest, opts ...grpc.CallOption) (*MembersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MembersResponse)
+	err := c.cc.Invoke(ctx, BeaverVault_Members_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BeaverVaultServer is the server API for BeaverVault service.
+// All implementations must embed UnimplementedBeaverVaultServer
+// for forward compatibility.
+//
+// BeaverVault mirrors the HTTP KV and cluster endpoints.
+type BeaverVaultServer interface {
+	// Get reads a key. See GetRequest.consistency for read guarantees.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Put stores a JSON value. Must be sent to the leader.
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Delete removes a key. Must be sent to the leader.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Watch streams changes of a key or prefix as they are applied on the
+	// node serving the call. Any node can serve it.
+	Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error
+	// Join adds a node to the Raft cluster. Must be sent to the leader.
+	Join(context.Context, *JoinRequest) (*JoinResponse, error)
+	// Drop removes a node from the Raft cluster. Must be sent to the leader.
+	// A leader asked to drop itself transfers leadership instead and answers
+	// UNAVAILABLE naming the new leader, to which the drop has to be sent
+	// again.
+	Drop(context.Context, *DropRequest) (*DropResponse, error)
+	// Promote makes a non-voter a voter. Must be sent to the leader.
+	Promote(context.Context, *PromoteRequest) (*PromoteResponse, error)
+	// Demote makes a voter a non-voter. Like Drop, it keeps the minimum
+	// voters unless forced. Must be sent to the leader.
+	Demote(context.Context, *DemoteRequest) (*DemoteResponse, error)
+	// Stats returns the local node's Raft statistics.
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	// Members lists the servers of the Raft cluster. The leader also reports
+	// the replication progress of each follower.
+	Members(context.Context, *MembersRequest) (*MembersResponse, error)
+	mustEmbedUnimplementedBeaverVaultServer()
+}
+
+// UnimplementedBeaverVaultServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBeaverVaultServer struct{}
+
+func (UnimplementedBeaverVaultServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedBeaverVaultServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedBeaverVaultServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedBeaverVaultServer) Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedBeaverVaultServer) Join(context.Context, *JoinRequest) (*JoinResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Join not implemented")
+}
+func (UnimplementedBeaverVaultServer) Drop(context.Context, *DropRequest) (*DropResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drop not implemented")
+}
+func (UnimplementedBeaverVaultServer) Promote(context.Context, *PromoteRequest) (*PromoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Promote not implemented")
+}
+func (UnimplementedBeaverVaultServer) Demote(context.Context, *DemoteRequest) (*DemoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Demote not implemented")
+}
+func (UnimplementedBeaverVaultServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedBeaverVaultServer) Members(context.Context, *MembersRequest) (*MembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Members not implemented")
+}
+func (UnimplementedBeaverVaultServer) mustEmbedUnimplementedBeaverVaultServer() {}
+func (UnimplementedBeaverVaultServer) testEmbeddedByValue()                     {}
+
+// UnsafeBeaverVaultServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BeaverVaultServer will
+// result in compilation errors.
+type UnsafeBeaverVaultServer interface {
+	mustEmbedUnimplementedBeaverVaultServer()
+}
+
+func RegisterBeaverVaultServer(s grpc.ServiceRegistrar, srv BeaverVaultServer) {
+	// If the following call pancis, it indicates UnimplementedBeaverVaultServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&BeaverVault_ServiceDesc, srv)
+}
+
+func _BeaverVault_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Put_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BeaverVaultServer).Watch(m, &grpc.GenericServerStream[WatchRequest, WatchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type BeaverVault_WatchServer = grpc.ServerStreamingServer[WatchEvent]
+
+func _BeaverVault_Join_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JoinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Join(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Join_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Join(ctx, req.(*JoinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Drop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DropRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Drop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Drop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Drop(ctx, req.(*DropRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Promote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PromoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Promote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Promote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Promote(ctx, req.(*PromoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Demote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DemoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Demote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Demote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Demote(ctx, req.(*DemoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BeaverVault_Members_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BeaverVaultServer).Members(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BeaverVault_Members_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BeaverVaultServer).Members(ctx, req.(*MembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// BeaverVault_ServiceDesc is the grpc.ServiceDesc for BeaverVault service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BeaverVault_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "beavervault.v1.BeaverVault",
+	HandlerType: (*BeaverVaultServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _BeaverVault_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _BeaverVault_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _BeaverVault_Delete_Handler,
+		},
+		{
+			MethodName: "Join",
+			Handler:    _BeaverVault_Join_Handler,
+		},
+		{
+			MethodName: "Drop",
+			Handler:    _BeaverVault_Drop_Handler,
+		},
+		{
+			MethodName: "Promote",
+			Handler:    _BeaverVault_Promote_Handler,
+		},
+		{
+			MethodName: "Demote",
+			Handler:    _BeaverVault_Demote_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _BeaverVault_Stats_Handler,
+		},
+		{
+			MethodName: "Members",
+			Handler:    _BeaverVault_Members_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _BeaverVault_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "beavervault.proto",
+}
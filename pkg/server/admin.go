@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+)
+
+// handleRotateKey handles POST requests that make a new data key active.
+// Values sealed with earlier keys are re-encrypted in the background, so it
+// answers 202; GET /api/v1/admin/encryption reports the progress.
+func (s *Server) handleRotateKey(c *gin.Context) {
+	keyID, err := s.handler.RotateKey()
+	if err != nil {
+		switch {
+		case errors.Is(err, handler.ErrRotationInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case notLeader(err):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"key_id": keyID})
+}
+
+// handleEncryptionStatus handles GET requests for the encryption status of
+// this node.
+func (s *Server) handleEncryptionStatus(c *gin.Context) {
+	status, err := s.handler.EncryptionStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
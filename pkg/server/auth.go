@@ -0,0 +1,260 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+)
+
+// contextToken is the gin context key of the authenticated token
+const contextToken = "beaver.token"
+
+// requestToken is the body of token creation requests.
+type requestToken struct {
+	Name     string   `json:"name"`
+	Policies []string `json:"policies"`
+	// TTL is a duration such as "24h" after which the token expires.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// requestPolicy is the body of policy writes.
+type requestPolicy struct {
+	Rules []handler.PolicyRule `json:"rules"`
+}
+
+// authenticate resolves the bearer token of a request and stores it in the
+// context. It answers 401 when auth is enabled and the token is missing or
+// invalid.
+func (s *Server) authenticate(c *gin.Context) {
+	token, err := s.handler.Authenticate(bearerToken(c.GetHeader("Authorization")))
+	if err != nil {
+		writeAuthError(c, err)
+		c.Abort()
+		return
+	}
+	c.Set(contextToken, token)
+	c.Next()
+}
+
+// authorize returns a middleware that answers 403 unless the request token
+// grants capability on the resource returned by resource.
+func (s *Server) authorize(capability string, resource func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.handler.Authorize(tokenFrom(c), resource(c), capability); err != nil {
+			writeAuthError(c, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// unlessKeysOnly runs check only for lists that return values, which need
+// read on top of list.
+func unlessKeysOnly(check gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if listKeysOnly(c) {
+			c.Next()
+			return
+		}
+		check(c)
+	}
+}
+
+// listKeysOnly reports whether a list asks for keys without values.
+func listKeysOnly(c *gin.Context) bool {
+	keysOnly, _ := strconv.ParseBool(c.Query("keys_only"))
+	return keysOnly
+}
+
+// kvKey is the resource of the key in the route.
+func kvKey(c *gin.Context) string {
+	return handler.KVResource(c.Param("key"))
+}
+
+// kvPrefix is the resource of the keys a list scans.
+func kvPrefix(c *gin.Context) string {
+	return handler.KVResource(c.Query("prefix"))
+}
+
+// secretPath is the resource of the secret in the route.
+func secretPath(c *gin.Context) string {
+	path, _ := splitSecretPath(c)
+	return handler.SecretResource(path)
+}
+
+// watchedKeys is the resource of the key or prefix a watch follows.
+func watchedKeys(c *gin.Context) string {
+	if key := c.Query("key"); key != "" {
+		return handler.KVResource(key)
+	}
+	return handler.KVResource(c.Query("prefix"))
+}
+
+// resource returns a resource func for a fixed resource.
+func resource(name string) func(c *gin.Context) string {
+	return func(*gin.Context) string { return name }
+}
+
+// tokenFrom returns the token stored by authenticate, or nil.
+func tokenFrom(c *gin.Context) *handler.Token {
+	token, _ := c.Get(contextToken)
+	t, _ := token.(*handler.Token)
+	return t
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// writeAuthError answers a failed authentication or authorization.
+func writeAuthError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, handler.ErrUnauthenticated):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, handler.ErrPermissionDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// handleCreateToken handles POST requests that create a token. The secret is
+// only returned in this response.
+func (s *Server) handleCreateToken(c *gin.Context) {
+	var req requestToken
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ttl"})
+			return
+		}
+	}
+
+	secret, token, err := s.handler.CreateToken(handler.RequestToken{
+		Name:     req.Name,
+		Policies: req.Policies,
+		TTL:      ttl,
+	})
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": secret, "accessor": token.Accessor, "policies": token.Policies, "expires_at": token.ExpiresAt})
+}
+
+// handleListTokens handles GET requests for every token, without secrets.
+func (s *Server) handleListTokens(c *gin.Context) {
+	tokens, err := s.handler.ListTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// handleGetToken handles GET requests for the token with an accessor.
+func (s *Server) handleGetToken(c *gin.Context) {
+	token, err := s.handler.GetToken(c.Param("accessor"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if token == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
+
+// handleRevokeToken handles DELETE requests that revoke a token.
+func (s *Server) handleRevokeToken(c *gin.Context) {
+	found, err := s.handler.RevokeToken(c.Param("accessor"))
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handlePutPolicy handles PUT requests that create or replace a policy.
+func (s *Server) handlePutPolicy(c *gin.Context) {
+	var req requestPolicy
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := s.handler.PutPolicy(handler.Policy{Name: c.Param("name"), Rules: req.Rules}); err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleGetPolicy handles GET requests for a policy.
+func (s *Server) handleGetPolicy(c *gin.Context) {
+	policy, err := s.handler.GetPolicy(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if policy == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// handleListPolicies handles GET requests for the names of every policy.
+func (s *Server) handleListPolicies(c *gin.Context) {
+	names, err := s.handler.ListPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policies": names})
+}
+
+// handleDeletePolicy handles DELETE requests that remove a policy.
+func (s *Server) handleDeletePolicy(c *gin.Context) {
+	if err := s.handler.DeletePolicy(c.Param("name")); err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// writeAdminError answers a failed cluster, token or policy write.
+func writeAdminError(c *gin.Context, err error) {
+	if notLeader(err) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// notLeader reports whether err means the request has to go to the leader,
+// either because this node is not the leader or because it lost leadership
+// while applying the write.
+func notLeader(err error) bool {
+	return err.Error() == "not the leader" || handler.IsLeadershipError(err)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+)
+
+// writeConditions reads the conditional-write parameters of a request.
+// The mod index can be given as ?cas=<index> or as an If-Match header
+// (If-Match: * only requires the key to exist). If-None-Match: * and
+// ?mode=create only create new keys, ?mode=update only replaces existing ones.
+func writeConditions(c *gin.Context) (string, *uint64, error) {
+	mode := c.Query("mode")
+
+	var cas *uint64
+	if raw := c.Query("cas"); raw != "" {
+		index, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid cas index")
+		}
+		cas = &index
+	}
+
+	if raw := c.GetHeader("If-Match"); raw != "" {
+		if raw == "*" {
+			mode = fsm.WriteModeUpdate
+		} else {
+			index, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(raw, "W/"), `"`), 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid If-Match header")
+			}
+			cas = &index
+		}
+	}
+
+	if c.GetHeader("If-None-Match") == "*" {
+		mode = fsm.WriteModeCreate
+	}
+
+	return mode, cas, nil
+}
+
+// etag formats a mod index as an HTTP entity tag.
+func etag(modIndex uint64) string {
+	return `"` + strconv.FormatUint(modIndex, 10) + `"`
+}
+
+// abortOnConflict answers 409 if err is a failed conditional write.
+func abortOnConflict(c *gin.Context, err error) bool {
+	var conflict *fsm.ConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": conflict.Error(), "mod_index": conflict.ModIndex})
+	return true
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+)
+
+// Supported values for Options.ForwardMode
+const (
+	ForwardProxy    = "proxy"
+	ForwardRedirect = "redirect"
+)
+
+// headerForwarded marks requests relayed by another node, so that a request
+// never bounces between followers that disagree about who the leader is.
+const headerForwarded = "X-Beaver-Forwarded"
+
+// handOffTimeout bounds how long a request waits to learn the new leader
+// after this node handed leadership over.
+const handOffTimeout = 5 * time.Second
+
+// forwardConsistentRead forwards reads that only the leader can serve.
+// Stale reads are always answered locally.
+func (s *Server) forwardConsistentRead(c *gin.Context) {
+	consistency, err := handler.ParseConsistency(c.Query("consistency"))
+	if err != nil || consistency == handler.ConsistencyStale {
+		c.Next()
+		return
+	}
+	s.forwardToLeader(c)
+}
+
+// forwardToLeader sends writes received by a follower to the current leader.
+// When the leader is unknown the request falls through to the route handler,
+// which answers with "not the leader".
+func (s *Server) forwardToLeader(c *gin.Context) {
+	if s.handler.IsLeader() || c.GetHeader(headerForwarded) != "" {
+		c.Next()
+		return
+	}
+
+	addr, err := s.handler.LeaderHTTPAddress()
+	if err != nil {
+		log.Printf("Cannot forward %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		c.Next()
+		return
+	}
+
+	s.forward(c, addr)
+}
+
+// forwardAfterHandOff sends a request to the node this leader just handed
+// leadership to, once this node knows the new leader's HTTP address.
+func (s *Server) forwardAfterHandOff(c *gin.Context) {
+	deadline := time.Now().Add(handOffTimeout)
+	for {
+		addr, err := s.handler.LeaderHTTPAddress()
+		if err == nil && !s.handler.IsLeader() {
+			s.forward(c, addr)
+			return
+		}
+		if time.Now().After(deadline) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "leadership was transferred but the new leader is unknown"})
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// forward redirects or proxies a request to the node at addr, according to
+// the forward mode.
+func (s *Server) forward(c *gin.Context, addr string) {
+	if s.opts.ForwardMode == ForwardRedirect {
+		target := url.URL{Scheme: s.scheme(), Host: addr, Path: c.Request.URL.Path, RawQuery: c.Request.URL.RawQuery}
+		c.Redirect(http.StatusTemporaryRedirect, target.String())
+		c.Abort()
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: s.scheme(), Host: addr})
+	if s.opts.TLS != nil {
+		// Built per request so that reloaded certificates apply
+		host, _, _ := net.SplitHostPort(addr)
+		proxy.Transport = &http.Transport{
+			TLSClientConfig:   s.opts.TLS.ClientConfig(host),
+			DisableKeepAlives: true,
+		}
+	}
+	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error forwarding to leader: " + err.Error()})
+	}
+	c.Request.Header.Set(headerForwarded, "1")
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// scheme is the URL scheme of the API of every node.
+func (s *Server) scheme() string {
+	if s.opts.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
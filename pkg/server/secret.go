@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+)
+
+// requestSecretVersions is the body of undelete and destroy requests.
+type requestSecretVersions struct {
+	Versions []uint64 `json:"versions"`
+}
+
+// secretActions are the trailing segments of a secret route that name an
+// operation on the secret rather than part of its path.
+var secretActions = map[string]bool{"metadata": true, "undelete": true, "destroy": true}
+
+// splitSecretPath splits the wildcard of a secret route into the secret path,
+// which may be nested, and the trailing action, if any.
+func splitSecretPath(c *gin.Context) (path, action string) {
+	path = strings.TrimPrefix(c.Param("path"), "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 && secretActions[path[i+1:]] {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// secretPathParam returns the secret path of a secret route.
+func secretPathParam(c *gin.Context) string {
+	path, _ := splitSecretPath(c)
+	return path
+}
+
+// secretRoute dispatches a secret route to the handler of its trailing
+// action; "" is the secret itself. A wildcard route has to end the pattern,
+// so the actions cannot have routes of their own.
+func secretRoute(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, action := splitSecretPath(c)
+		h, ok := handlers[action]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown secret operation"})
+			return
+		}
+		h(c)
+	}
+}
+
+// handlePutSecret handles PUT requests that write a new version of a secret.
+// The body is the JSON value; ?cas=<version> only writes if the current
+// version still equals it (0 if the secret must not exist).
+func (s *Server) handlePutSecret(c *gin.Context) {
+	var cas *uint64
+	if raw := c.Query("cas"); raw != "" {
+		version, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cas version"})
+			return
+		}
+		cas = &version
+	}
+
+	var value interface{}
+	if err := c.BindJSON(&value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	version, err := s.handler.PutSecret(c.Request.Context(), handler.RequestSecretPut{
+		Path:  secretPathParam(c),
+		Value: value,
+		CAS:   cas,
+	})
+	if err != nil {
+		writeSecretError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": secretPathParam(c), "version": version})
+}
+
+// handleGetSecret handles GET requests for the current version of a secret,
+// or the one selected by ?version=. Deleted and destroyed versions answer
+// 404 with their state.
+func (s *Server) handleGetSecret(c *gin.Context) {
+	consistency, err := handler.ParseConsistency(c.Query("consistency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var version uint64
+	if raw := c.Query("version"); raw != "" {
+		if version, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+			return
+		}
+	}
+
+	secret, err := s.handler.GetSecret(secretPathParam(c), version, consistency)
+	if err != nil {
+		writeSecretError(c, err)
+		return
+	}
+	switch {
+	case secret == nil:
+		c.JSON(http.StatusNotFound, gin.H{"error": "secret not found"})
+	case secret.Destroyed:
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("version %d is destroyed", secret.Version), "secret": secret})
+	case secret.DeleteIndex != 0:
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("version %d is deleted", secret.Version), "secret": secret})
+	default:
+		c.JSON(http.StatusOK, secret)
+	}
+}
+
+// handleDeleteSecret handles DELETE requests that soft delete the current
+// version of a secret, or the comma-separated ?versions= list.
+func (s *Server) handleDeleteSecret(c *gin.Context) {
+	var versions []uint64
+	if raw := c.Query("versions"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			version, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid versions"})
+				return
+			}
+			versions = append(versions, version)
+		}
+	}
+
+	if err := s.handler.DeleteSecret(secretPathParam(c), versions); err != nil {
+		writeSecretError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleUndeleteSecret handles POST requests that restore soft deleted
+// versions of a secret.
+func (s *Server) handleUndeleteSecret(c *gin.Context) {
+	s.handleSecretVersions(c, s.handler.UndeleteSecret)
+}
+
+// handleDestroySecret handles POST requests that permanently remove versions
+// of a secret.
+func (s *Server) handleDestroySecret(c *gin.Context) {
+	s.handleSecretVersions(c, s.handler.DestroySecret)
+}
+
+// handleSecretVersions applies op to the versions listed in the request body.
+func (s *Server) handleSecretVersions(c *gin.Context, op func(path string, versions []uint64) error) {
+	var req requestSecretVersions
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := op(secretPathParam(c), req.Versions); err != nil {
+		writeSecretError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleGetSecretMetadata handles GET requests for the version metadata of a
+// secret.
+func (s *Server) handleGetSecretMetadata(c *gin.Context) {
+	consistency, err := handler.ParseConsistency(c.Query("consistency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	meta, err := s.handler.SecretMetadata(secretPathParam(c), consistency)
+	if err != nil {
+		writeSecretError(c, err)
+		return
+	}
+	if meta == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "secret not found"})
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
+
+// handleDeleteSecretMetadata handles DELETE requests that remove a secret
+// with all its versions.
+func (s *Server) handleDeleteSecretMetadata(c *gin.Context) {
+	if err := s.handler.PurgeSecret(secretPathParam(c)); err != nil {
+		writeSecretError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// writeSecretError answers a failed secret operation.
+func writeSecretError(c *gin.Context, err error) {
+	if abortOnConflict(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, fsm.ErrSecretNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case notLeader(err):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -1,27 +1,69 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/subash-0044/beaver-vault/pkg/consensus"
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
 	"github.com/subash-0044/beaver-vault/pkg/handler"
+	"github.com/subash-0044/beaver-vault/pkg/metrics"
+	"github.com/subash-0044/beaver-vault/pkg/tlsutil"
 )
 
+// binaryContentType marks request and response bodies holding binary values
+const binaryContentType = "application/octet-stream"
+
+// Options configures optional Server behaviour
+type Options struct {
+	// ForwardMode controls how followers treat writes: ForwardProxy (the
+	// default) relays them to the leader, ForwardRedirect answers with a
+	// 307 pointing at the leader.
+	ForwardMode string
+	// TLS, if set, serves the API over HTTPS with its certificate, and
+	// forwarded requests reach the leader over HTTPS too
+	TLS *tlsutil.Reloader
+	// ClientAuth requires clients to present a certificate signed by the
+	// CA of TLS
+	ClientAuth bool
+	// Metrics, if set, records the latency of every request and is served
+	// at /metrics
+	Metrics *metrics.Metrics
+}
+
 // Server represents the HTTP server
 type Server struct {
 	handler   *handler.Handler
 	consensus *consensus.Raft
 	router    *gin.Engine
+	opts      Options
+
+	mu  sync.Mutex
+	srv *http.Server
+	// closing is closed by Shutdown to end watch streams, which would
+	// otherwise keep the server from draining
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
 // NewGinServer creates a new HTTP server instance
-func NewGinServer(h *handler.Handler, c *consensus.Raft) *Server {
+func NewGinServer(h *handler.Handler, c *consensus.Raft, opts Options) *Server {
 	s := &Server{
 		handler:   h,
 		consensus: c,
 		router:    gin.Default(),
+		opts:      opts,
+		closing:   make(chan struct{}),
 	}
 	// Load HTML templates
 	s.router.LoadHTMLGlob("templates/*")
@@ -31,6 +73,13 @@ func NewGinServer(h *handler.Handler, c *consensus.Raft) *Server {
 
 // setupRoutes configures all the routes for the server
 func (s *Server) setupRoutes() {
+	// Prometheus metrics, which need read on sys/metrics when auth is enabled
+	if s.opts.Metrics != nil {
+		s.router.Use(s.observeRequest)
+		s.router.GET("/metrics", s.authenticate, s.authorize(handler.CapabilityRead, resource(handler.ResourceMetrics)),
+			gin.WrapH(s.opts.Metrics.Handler()))
+	}
+
 	// Health check
 	s.router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -41,58 +90,255 @@ func (s *Server) setupRoutes() {
 		c.HTML(http.StatusOK, "raft_ui.html", nil)
 	})
 
-	// API routes
-	v1 := s.router.Group("/api/v1")
+	// API routes. Every request is authenticated when auth is enabled, and
+	// each route checks its own capability.
+	v1 := s.router.Group("/api/v1", s.authenticate)
 	{
-		// Key-Value operations
-		v1.GET("/kv/:key", s.handleGet)
-		v1.PUT("/kv/:key", s.handleSet)
-		v1.DELETE("/kv/:key", s.handleDelete)
+		read := func(resource func(c *gin.Context) string) gin.HandlerFunc {
+			return s.authorize(handler.CapabilityRead, resource)
+		}
+		write := func(resource func(c *gin.Context) string) gin.HandlerFunc {
+			return s.authorize(handler.CapabilityWrite, resource)
+		}
+		admin := func(resource func(c *gin.Context) string) gin.HandlerFunc {
+			return s.authorize(handler.CapabilityAdmin, resource)
+		}
+
+		// Key-Value operations. Batches and transactions check each key.
+		v1.GET("/kv", s.authorize(handler.CapabilityList, kvPrefix), unlessKeysOnly(read(kvPrefix)), s.forwardConsistentRead, s.handleList)
+		v1.POST("/kv/batch", s.forwardToLeader, s.handleBatch)
+		v1.GET("/kv/:key", read(kvKey), s.forwardConsistentRead, s.handleGet)
+		v1.PUT("/kv/:key", write(kvKey), s.forwardToLeader, s.handleSet)
+		v1.DELETE("/kv/:key", write(kvKey), s.forwardToLeader, s.handleDelete)
+		v1.POST("/txn", s.forwardToLeader, s.handleTxn)
+		v1.GET("/watch", read(watchedKeys), s.handleWatch)
+
+		// Secret engine
+		v1.PUT("/secret/*path", write(secretPath), s.forwardToLeader, secretRoute(map[string]gin.HandlerFunc{
+			"": s.handlePutSecret,
+		}))
+		v1.GET("/secret/*path", read(secretPath), s.forwardConsistentRead, secretRoute(map[string]gin.HandlerFunc{
+			"":         s.handleGetSecret,
+			"metadata": s.handleGetSecretMetadata,
+		}))
+		v1.DELETE("/secret/*path", write(secretPath), s.forwardToLeader, secretRoute(map[string]gin.HandlerFunc{
+			"":         s.handleDeleteSecret,
+			"metadata": s.handleDeleteSecretMetadata,
+		}))
+		v1.POST("/secret/*path", write(secretPath), s.forwardToLeader, secretRoute(map[string]gin.HandlerFunc{
+			"undelete": s.handleUndeleteSecret,
+			"destroy":  s.handleDestroySecret,
+		}))
+
+		// Admin operations
+		v1.POST("/admin/rotate-key", admin(resource(handler.ResourceEncryption)), s.forwardToLeader, s.handleRotateKey)
+		v1.GET("/admin/encryption", read(resource(handler.ResourceEncryption)), s.handleEncryptionStatus)
+
+		// Tokens and policies
+		authAdmin := admin(resource(handler.ResourceAuth))
+		v1.POST("/auth/tokens", authAdmin, s.forwardToLeader, s.handleCreateToken)
+		v1.GET("/auth/tokens", authAdmin, s.handleListTokens)
+		v1.GET("/auth/tokens/:accessor", authAdmin, s.handleGetToken)
+		v1.DELETE("/auth/tokens/:accessor", authAdmin, s.forwardToLeader, s.handleRevokeToken)
+		v1.GET("/auth/policies", authAdmin, s.handleListPolicies)
+		v1.PUT("/auth/policies/:name", authAdmin, s.forwardToLeader, s.handlePutPolicy)
+		v1.GET("/auth/policies/:name", authAdmin, s.handleGetPolicy)
+		v1.DELETE("/auth/policies/:name", authAdmin, s.forwardToLeader, s.handleDeletePolicy)
 
 		// Raft operations
-		v1.POST("/raft/join", s.handleJoin)
-		v1.POST("/raft/drop", s.handleDrop)
-		v1.GET("/raft/stat", s.handleStat)
+		v1.POST("/raft/join", admin(resource(handler.ResourceRaft)), s.forwardToLeader, s.handleJoin)
+		v1.POST("/raft/drop", admin(resource(handler.ResourceRaft)), s.forwardToLeader, s.handleDrop)
+		v1.POST("/raft/promote", admin(resource(handler.ResourceRaft)), s.forwardToLeader, s.handlePromote)
+		v1.POST("/raft/demote", admin(resource(handler.ResourceRaft)), s.forwardToLeader, s.handleDemote)
+		v1.GET("/raft/stat", read(resource(handler.ResourceRaft)), s.handleStat)
+		v1.GET("/raft/members", read(resource(handler.ResourceRaft)), s.handleMembers)
 	}
 }
 
-// Run starts the HTTP server
+// observeRequest records the latency of a request by route and status.
+func (s *Server) observeRequest(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	s.opts.Metrics.ObserveHTTP(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+}
+
+// Run starts the HTTP server, or the HTTPS server when TLS is configured.
+// It returns nil once Shutdown is called.
 func (s *Server) Run(addr string) error {
-	return s.router.Run(addr)
+	srv := &http.Server{Addr: addr, Handler: s.router}
+	s.mu.Lock()
+	s.srv = srv
+	s.mu.Unlock()
+
+	var err error
+	if s.opts.TLS == nil {
+		err = srv.ListenAndServe()
+	} else {
+		srv.TLSConfig = s.opts.TLS.ServerConfig(s.opts.ClientAuth)
+		err = srv.ListenAndServeTLS("", "")
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests, ends watch streams and waits for the
+// other in-flight requests to finish. When ctx expires first, the remaining
+// connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		if s.closing != nil {
+			close(s.closing)
+		}
+	})
+
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	return nil
 }
 
-// handleGet handles GET requests for key-value pairs
+// handleGet handles GET requests for key-value pairs.
+// Binary values are returned as application/octet-stream, with the mod
+// index in the ETag header.
+// The optional consistency query parameter selects stale, leader or
+// linearizable reads.
 func (s *Server) handleGet(c *gin.Context) {
 	key := c.Param("key")
-	value, err := s.handler.Get(key)
+	consistency, err := handler.ParseConsistency(c.Query("consistency"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if value == nil {
+
+	entry, err := s.handler.GetEntry(key, consistency)
+	if err != nil {
+		if notLeader(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if entry == nil || entry.Value == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"key": key, "value": value})
+	c.Header("ETag", etag(entry.ModIndex))
+	if entry.Type == fsm.ValueTypeBinary {
+		c.Data(http.StatusOK, binaryContentType, entry.Value.([]byte))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"key":          key,
+		"value":        entry.Value,
+		"create_index": entry.CreateIndex,
+		"mod_index":    entry.ModIndex,
+	})
 }
 
-// handleSet handles PUT requests for key-value pairs
+// handleList handles GET requests that scan keys by prefix and/or range.
+// Query parameters: prefix, start, end, limit, cursor, keys_only and consistency.
+func (s *Server) handleList(c *gin.Context) {
+	consistency, err := handler.ParseConsistency(c.Query("consistency"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var limit int
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	resp, err := s.handler.List(handler.RequestList{
+		Prefix:      c.Query("prefix"),
+		Start:       c.Query("start"),
+		End:         c.Query("end"),
+		Limit:       limit,
+		Cursor:      c.Query("cursor"),
+		KeysOnly:    listKeysOnly(c),
+		Consistency: consistency,
+	})
+	if err != nil {
+		if notLeader(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleSet handles PUT requests for key-value pairs.
+// The body is a JSON value, or raw bytes stored as a binary value when the
+// Content-Type is application/octet-stream.
+// The optional ttl query parameter (e.g. 30s) expires the key, and
+// writeConditions describes the conditional-write parameters.
 func (s *Server) handleSet(c *gin.Context) {
 	key := c.Param("key")
+
+	mode, cas, err := writeConditions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ttl time.Duration
+	if raw := c.Query("ttl"); raw != "" {
+		if ttl, err = time.ParseDuration(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ttl"})
+			return
+		}
+	}
+
 	var value interface{}
-	if err := c.BindJSON(&value); err != nil {
+	var data []byte
+	if c.ContentType() == binaryContentType {
+		if data, err = io.ReadAll(c.Request.Body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	} else if err := c.BindJSON(&value); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
 
-	err := s.handler.Store(c.Request.Context(), handler.RequestStore{
-		Key:   key,
-		Value: value,
+	err = s.handler.Store(c.Request.Context(), handler.RequestStore{
+		Key:      key,
+		Value:    value,
+		Data:     data,
+		TTL:      ttl,
+		Mode:     mode,
+		CASIndex: cas,
 	})
 	if err != nil {
-		const errNotLeader = "not the leader"
-		if err.Error() == errNotLeader {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		if abortOnConflict(c, err) {
+			return
+		}
+		if errors.Is(err, handler.ErrWriteQueueFull) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if notLeader(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -102,14 +348,27 @@ func (s *Server) handleSet(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// handleDelete handles DELETE requests for key-value pairs
+// handleDelete handles DELETE requests for key-value pairs.
+// A cas query parameter or If-Match header makes the delete conditional.
 func (s *Server) handleDelete(c *gin.Context) {
 	key := c.Param("key")
-	err := s.handler.Delete(key)
+	_, cas, err := writeConditions(c)
 	if err != nil {
-		const errNotLeader = "not the leader"
-		if err.Error() == errNotLeader {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "not the leader"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = s.handler.DeleteWithCAS(key, cas)
+	if err != nil {
+		if abortOnConflict(c, err) {
+			return
+		}
+		if errors.Is(err, handler.ErrWriteQueueFull) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if notLeader(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -119,6 +378,60 @@ func (s *Server) handleDelete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// handleBatch handles POST requests that apply an array of set and delete
+// operations as one Raft log entry.
+func (s *Server) handleBatch(c *gin.Context) {
+	var ops []handler.RequestBatchOp
+	if err := c.BindJSON(&ops); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := s.handler.AuthorizeBatch(tokenFrom(c), ops); err != nil {
+		writeAuthError(c, err)
+		return
+	}
+
+	results, err := s.handler.Batch(c.Request.Context(), ops)
+	if err != nil {
+		if notLeader(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// handleTxn handles POST requests that apply several operations atomically.
+func (s *Server) handleTxn(c *gin.Context) {
+	var req handler.RequestTxn
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := s.handler.AuthorizeTxn(tokenFrom(c), req); err != nil {
+		writeAuthError(c, err)
+		return
+	}
+
+	result, err := s.handler.Txn(c.Request.Context(), req)
+	if err != nil {
+		if abortOnConflict(c, err) {
+			return
+		}
+		if notLeader(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 // handleJoin handles POST requests to join a new node to the Raft cluster
 func (s *Server) handleJoin(c *gin.Context) {
 	var req consensus.RequestJoin
@@ -128,7 +441,7 @@ func (s *Server) handleJoin(c *gin.Context) {
 	}
 	success, err := s.consensus.JoinRaftHandler(req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeAdminError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"success": success})
@@ -136,19 +449,70 @@ func (s *Server) handleJoin(c *gin.Context) {
 
 // handleDrop handles POST requests to remove a node from the Raft cluster
 func (s *Server) handleDrop(c *gin.Context) {
+	body, err := c.GetRawData()
 	var req consensus.RequestDrop
+	if err != nil || json.Unmarshal(body, &req) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	result, err := s.consensus.DropRaftHandler(req)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	if !result.Removed && result.TransferredTo != "" {
+		// This leader dropped itself: it handed leadership over and the new
+		// leader removes it
+		log.Printf("Transferred leadership to %s before dropping node %s", result.TransferredTo, req.NodeID)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		s.forwardAfterHandOff(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": result})
+}
+
+// handlePromote handles POST requests to make a non-voter a voter
+func (s *Server) handlePromote(c *gin.Context) {
+	var req consensus.RequestSuffrage
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
-	success, err := s.consensus.DropRaftHandler(req)
+	success, err := s.consensus.PromoteRaftHandler(req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeAdminError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"success": success})
 }
 
+// handleDemote handles POST requests to make a voter a non-voter
+func (s *Server) handleDemote(c *gin.Context) {
+	var req consensus.RequestSuffrage
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	success, err := s.consensus.DemoteRaftHandler(req)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": success})
+}
+
+// handleMembers handles GET requests for the members of the Raft cluster.
+// The leader also reports the replication progress of each follower.
+func (s *Server) handleMembers(c *gin.Context) {
+	members, err := s.consensus.MembersRaftHandler()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
 // handleStat handles GET requests to retrieve Raft cluster stats
 func (s *Server) handleStat(c *gin.Context) {
 	stats, err := s.consensus.StatsRaftHandler()
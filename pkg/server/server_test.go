@@ -1,12 +1,19 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,11 +21,17 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
+	"github.com/subash-0044/beaver-vault/pkg/consensus"
+	"github.com/subash-0044/beaver-vault/pkg/encryption"
 	"github.com/subash-0044/beaver-vault/pkg/fsm"
 	"github.com/subash-0044/beaver-vault/pkg/handler"
+	"github.com/subash-0044/beaver-vault/pkg/metrics"
 )
 
-func setupTestServer(t *testing.T) (*Server, string, func()) {
+// newTestRaft starts a Raft node backed by a fresh BadgerDB. Only the
+// bootstrap node forms a cluster; others wait to be joined. Committed changes
+// are published to watcher if it is not nil.
+func newTestRaft(t *testing.T, nodeID string, bootstrap bool, watcher *fsm.Watcher) (*raft.Raft, *badger.DB, raft.ServerAddress, func()) {
 	tmpDir, err := os.MkdirTemp("", "raft-test-server")
 	assert.NoError(t, err)
 
@@ -28,7 +41,7 @@ func setupTestServer(t *testing.T) (*Server, string, func()) {
 	assert.NoError(t, err)
 
 	config := raft.DefaultConfig()
-	config.LocalID = raft.ServerID("node1")
+	config.LocalID = raft.ServerID(nodeID)
 	config.HeartbeatTimeout = 100 * time.Millisecond
 	config.ElectionTimeout = 100 * time.Millisecond
 	config.LeaderLeaseTimeout = 100 * time.Millisecond
@@ -46,47 +59,35 @@ func setupTestServer(t *testing.T) (*Server, string, func()) {
 	transport, err := raft.NewTCPTransport("localhost:0", nil, 3, 10*time.Second, nil)
 	assert.NoError(t, err)
 
-	fsmStore := fsm.New(db)
+	fsmStore := fsm.NewWithWatcher(db, watcher)
 
 	ra, err := raft.NewRaft(config, fsmStore, logStore, stableStore, snapshotStore, transport)
 	assert.NoError(t, err)
 
-	configuration := raft.Configuration{
-		Servers: []raft.Server{
-			{
-				ID:      config.LocalID,
-				Address: transport.LocalAddr(),
+	if bootstrap {
+		configuration := raft.Configuration{
+			Servers: []raft.Server{
+				{
+					ID:      config.LocalID,
+					Address: transport.LocalAddr(),
+				},
 			},
-		},
-	}
-	ra.BootstrapCluster(configuration)
-
-	// Wait for leader election with timeout
-	timeout := time.After(5 * time.Second)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-timeout:
-			t.Fatal("Timeout waiting for leader election")
-		case <-ticker.C:
-			if ra.State() == raft.Leader {
-				goto leaderElected
+		}
+		ra.BootstrapCluster(configuration)
+
+		// Wait for leader election with timeout
+		timeout := time.After(5 * time.Second)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for ra.State() != raft.Leader {
+			select {
+			case <-timeout:
+				t.Fatal("Timeout waiting for leader election")
+			case <-ticker.C:
 			}
 		}
 	}
-leaderElected:
-
-	h := handler.NewActionHandler(ra, db)
-
-	// Create a test-specific server without template loading
-	s := &Server{
-		handler:   h,
-		consensus: nil,
-		router:    gin.New(),
-	}
-	s.setupRoutes()
 
 	cleanup := func() {
 		if err := ra.Shutdown().Error(); err != nil {
@@ -106,7 +107,28 @@ leaderElected:
 		}
 	}
 
-	return s, tmpDir, cleanup
+	return ra, db, transport.LocalAddr(), cleanup
+}
+
+// newTestServer wraps a Raft node in a test-specific server without template loading
+func newTestServer(ra *raft.Raft, db *badger.DB, opts Options) *Server {
+	s := &Server{
+		handler:   handler.NewActionHandler(ra, db),
+		consensus: consensus.NewRaftObj(ra),
+		router:    gin.New(),
+		opts:      opts,
+		closing:   make(chan struct{}),
+	}
+	s.setupRoutes()
+	return s
+}
+
+func setupTestServer(t *testing.T) (*Server, string, func()) {
+	watcher := fsm.NewWatcher(fsm.DefaultWatchHistory)
+	ra, db, _, cleanup := newTestRaft(t, "node1", true, watcher)
+	s := newTestServer(ra, db, Options{})
+	s.handler.WithWatcher(watcher)
+	return s, "", cleanup
 }
 
 func TestHealthCheck(t *testing.T) {
@@ -150,6 +172,13 @@ func TestKeyValueOperations(t *testing.T) {
 		assert.Equal(t, "ok", response["status"])
 	})
 
+	t.Run("Set Value With Invalid TTL", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/v1/kv/ttl-key?ttl=soon", bytes.NewBufferString(`"value"`))
+		s.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	// Wait for write to be committed
 	time.Sleep(200 * time.Millisecond)
 
@@ -168,6 +197,39 @@ func TestKeyValueOperations(t *testing.T) {
 		assert.Equal(t, float64(30), value["age"])
 	})
 
+	t.Run("Get With Consistency", func(t *testing.T) {
+		for _, consistency := range []string{"stale", "leader", "linearizable"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/kv/test-key?consistency="+consistency, nil)
+			s.router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, consistency)
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/kv/test-key?consistency=bogus", nil)
+		s.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("List Keys", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/kv?prefix=test-&keys_only=true", nil)
+		s.router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Items []map[string]interface{} `json:"items"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, []map[string]interface{}{{"key": "test-key"}}, response.Items)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/kv?limit=abc", nil)
+		s.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	t.Run("Get Non-existent Key", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/v1/kv/non-existent", nil)
@@ -200,3 +262,750 @@ func TestKeyValueOperations(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 }
+
+func TestFollowerForwarding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	leaderRaft, leaderDB, _, leaderCleanup := newTestRaft(t, "node1", true, nil)
+	defer leaderCleanup()
+	leader := newTestServer(leaderRaft, leaderDB, Options{})
+	leaderHTTP := httptest.NewServer(leader.router)
+	defer leaderHTTP.Close()
+
+	leader.consensus.AdvertiseHTTP(leaderHTTP.Listener.Addr().String())
+	defer func() { _ = leader.consensus.Close() }()
+
+	followerRaft, followerDB, followerAddr, followerCleanup := newTestRaft(t, "node2", false, nil)
+	defer followerCleanup()
+
+	success, err := leader.consensus.JoinRaftHandler(consensus.RequestJoin{
+		NodeID:      "node2",
+		RaftAddress: string(followerAddr),
+		HTTPAddress: "127.0.0.1:1",
+	})
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	// Wait for the follower to learn the leader and its published address
+	followerHandler := handler.NewActionHandler(followerRaft, followerDB)
+	timeout := time.Now().Add(5 * time.Second)
+	for time.Now().Before(timeout) {
+		if _, err := followerHandler.LeaderHTTPAddress(); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	addr, err := followerHandler.LeaderHTTPAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, leaderHTTP.Listener.Addr().String(), addr)
+
+	t.Run("Proxy", func(t *testing.T) {
+		follower := newTestServer(followerRaft, followerDB, Options{ForwardMode: ForwardProxy})
+		// ReverseProxy needs a real connection rather than a ResponseRecorder
+		followerHTTP := httptest.NewServer(follower.router)
+		defer followerHTTP.Close()
+
+		req, _ := http.NewRequest("PUT", followerHTTP.URL+"/api/v1/kv/forwarded-key", bytes.NewBufferString(`"forwarded-value"`))
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		time.Sleep(200 * time.Millisecond)
+		value, err := followerHandler.Get("forwarded-key")
+		assert.NoError(t, err)
+		assert.Equal(t, "forwarded-value", value)
+
+		req, _ = http.NewRequest("DELETE", followerHTTP.URL+"/api/v1/kv/forwarded-key", nil)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		follower := newTestServer(followerRaft, followerDB, Options{ForwardMode: ForwardRedirect})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/v1/kv/redirected-key", bytes.NewBufferString(`"value"`))
+		follower.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "http://"+addr+"/api/v1/kv/redirected-key", w.Header().Get("Location"))
+
+		// Cluster changes go to the leader too, so any node can be a join seed
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/api/v1/raft/join", bytes.NewBufferString(`{"NodeID": "node3"}`))
+		follower.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "http://"+addr+"/api/v1/raft/join", w.Header().Get("Location"))
+	})
+
+	t.Run("Already Forwarded", func(t *testing.T) {
+		follower := newTestServer(followerRaft, followerDB, Options{})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/v1/kv/loop-key", bytes.NewBufferString(`"value"`))
+		req.Header.Set(headerForwarded, "1")
+		follower.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestConditionalWrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	do := func(method, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+	modIndex := func() float64 {
+		w := do("GET", "/api/v1/kv/cas-key", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, w.Header().Get("ETag"), fmt.Sprintf(`"%v"`, response["mod_index"]))
+		return response["mod_index"].(float64)
+	}
+
+	// Create-only
+	assert.Equal(t, http.StatusOK, do("PUT", "/api/v1/kv/cas-key?mode=create", `"v1"`, nil).Code)
+	assert.Equal(t, http.StatusConflict, do("PUT", "/api/v1/kv/cas-key", `"v1"`, map[string]string{"If-None-Match": "*"}).Code)
+	first := modIndex()
+
+	// CAS through the query string and If-Match
+	assert.Equal(t, http.StatusConflict, do("PUT", fmt.Sprintf("/api/v1/kv/cas-key?cas=%v", first-1), `"v2"`, nil).Code)
+	assert.Equal(t, http.StatusOK, do("PUT", fmt.Sprintf("/api/v1/kv/cas-key?cas=%v", first), `"v2"`, nil).Code)
+	second := modIndex()
+	assert.Greater(t, second, first)
+
+	w := do("PUT", "/api/v1/kv/cas-key", `"v3"`, map[string]string{"If-Match": fmt.Sprintf(`"%v"`, first)})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var conflict map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &conflict))
+	assert.Equal(t, second, conflict["mod_index"])
+
+	// Update-only
+	assert.Equal(t, http.StatusConflict, do("PUT", "/api/v1/kv/other-key?mode=update", `"v"`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do("PUT", "/api/v1/kv/cas-key?cas=abc", `"v"`, nil).Code)
+
+	// Conditional delete
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/api/v1/kv/cas-key", "", map[string]string{"If-Match": fmt.Sprintf(`"%v"`, first)}).Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/kv/cas-key", "", map[string]string{"If-Match": fmt.Sprintf(`"%v"`, second)}).Code)
+}
+
+func TestTxn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	txn := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/txn", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		s.router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	w, response := txn(`{
+		"guards": [{"key": "app/version", "exists": false}],
+		"then": [
+			{"op": "set", "key": "app/config/v1", "value": {"replicas": 3}},
+			{"op": "set", "key": "app/version", "value": "v1"}
+		]
+	}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, response["succeeded"])
+	assert.Len(t, response["results"], 2)
+
+	w, response = txn(`{
+		"guards": [{"key": "app/version", "exists": false}],
+		"then": [{"op": "set", "key": "app/version", "value": "v2"}],
+		"else": [{"op": "get", "key": "app/version"}]
+	}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, false, response["succeeded"])
+	results := response["results"].([]interface{})
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "v1", results[0].(map[string]interface{})["value"])
+	}
+
+	w, _ = txn(`{"then": [{"op": "set", "key": "app/version", "value": "v2", "cas_index": 1}]}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, _ = txn(`{"then": [{"op": "set", "key": "_beaver/nodes/x", "value": "v"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = txn(`{"then": [{"op": "rename", "key": "app/version"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ts := httptest.NewServer(s.router)
+	defer ts.Close()
+
+	put := func(key, value string) {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/kv/"+key, bytes.NewBufferString(value))
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// watch opens a stream and returns a function reading the next event
+	watch := func(query string, header http.Header) (*http.Response, func() (string, string, map[string]interface{})) {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/v1/watch?"+query, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		reader := bufio.NewReader(resp.Body)
+		return resp, func() (string, string, map[string]interface{}) {
+			var id, name string
+			var data map[string]interface{}
+			for {
+				line, err := reader.ReadString('\n')
+				assert.NoError(t, err)
+				line = strings.TrimSuffix(line, "\n")
+				switch {
+				case line == "":
+					return id, name, data
+				case strings.HasPrefix(line, "id: "):
+					id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					name = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data))
+				}
+			}
+		}
+	}
+
+	resp, next := watch("prefix=app-", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	put("other", `"x"`)
+	put("app-a", `"v1"`)
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/v1/kv/app-a", nil)
+	delResp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	_ = delResp.Body.Close()
+
+	firstID, name, data := next()
+	assert.Equal(t, "put", name)
+	assert.Equal(t, "app-a", data["key"])
+	assert.Equal(t, "v1", data["value"])
+	assert.Equal(t, firstID, fmt.Sprintf("%v", data["index"]))
+
+	_, name, data = next()
+	assert.Equal(t, "delete", name)
+	assert.Equal(t, "app-a", data["key"])
+	_ = resp.Body.Close()
+
+	// Resume after the first event from history
+	resp, next = watch("key=app-a", http.Header{"Last-Event-Id": {firstID}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, name, _ = next()
+	assert.Equal(t, "delete", name)
+	_ = resp.Body.Close()
+
+	// Bad requests
+	resp, _ = watch("", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+	resp, _ = watch("prefix=_beaver/", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+	resp, _ = watch("key=app-a&index=1", nil)
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func TestBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	batch := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/kv/batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		s.router.ServeHTTP(w, req)
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	w, response := batch(`[
+		{"op": "set", "key": "bulk-1", "value": "one"},
+		{"op": "set", "key": "bulk-2", "value": {"n": 2}, "ttl": "1h"},
+		{"op": "delete", "key": "bulk-3"}
+	]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, response["results"], 3)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/kv/bulk-2", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, body := range []string{
+		`[]`,
+		`{"op": "set"}`,
+		`[{"op": "set", "key": "bulk-1"}]`,
+		`[{"op": "get", "key": "bulk-1"}]`,
+		`[{"op": "set", "key": "_beaver/x", "value": 1}]`,
+	} {
+		w, _ = batch(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestBinaryValues(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	blob := []byte{0x00, 0x01, 0xfe, 0xff, '"'}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/kv/blob", bytes.NewReader(blob))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/kv/blob", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Equal(t, blob, w.Body.Bytes())
+
+	// Listing reports the type and base64-encodes the bytes
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/kv?prefix=blob", nil)
+	s.router.ServeHTTP(w, req)
+	var list handler.ResponseList
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.Len(t, list.Items, 1) {
+		assert.Equal(t, fsm.ValueTypeBinary, list.Items[0].Type)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(blob), list.Items[0].Value)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/kv/blob", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRotateKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/admin/rotate-key", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "encryption is disabled")
+
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{5}, encryption.KeySize))
+	assert.NoError(t, err)
+	s.handler.WithKeyring(keyring)
+	defer s.handler.Close()
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/admin/rotate-key", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var rotated map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+	assert.NotEmpty(t, rotated["key_id"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/admin/encryption", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status handler.EncryptionStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Enabled)
+	assert.Equal(t, rotated["key_id"], status.ActiveKey)
+}
+
+func TestDrop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	leaderRaft, leaderDB, _, leaderCleanup := newTestRaft(t, "node1", true, nil)
+	defer leaderCleanup()
+	leader := newTestServer(leaderRaft, leaderDB, Options{})
+	leaderHTTP := httptest.NewServer(leader.router)
+	defer leaderHTTP.Close()
+
+	leader.consensus.AdvertiseHTTP(leaderHTTP.Listener.Addr().String())
+	defer func() { _ = leader.consensus.Close() }()
+
+	followerRaft, followerDB, followerAddr, followerCleanup := newTestRaft(t, "node2", false, nil)
+	defer followerCleanup()
+	follower := newTestServer(followerRaft, followerDB, Options{})
+	followerHTTP := httptest.NewServer(follower.router)
+	defer followerHTTP.Close()
+
+	success, err := leader.consensus.JoinRaftHandler(consensus.RequestJoin{
+		NodeID:      "node2",
+		RaftAddress: string(followerAddr),
+		HTTPAddress: followerHTTP.Listener.Addr().String(),
+	})
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	// Wait for the follower to catch up, so it can take over leadership
+	followerHandler := handler.NewActionHandler(followerRaft, followerDB)
+	timeout := time.Now().Add(5 * time.Second)
+	for time.Now().Before(timeout) {
+		if _, err := followerHandler.LeaderHTTPAddress(); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	drop := func(body string) (*http.Response, map[string]interface{}) {
+		resp, err := http.Post(leaderHTTP.URL+"/api/v1/raft/drop", "application/json", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		var decoded map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+		return resp, decoded
+	}
+
+	// Demoting keeps the same minimum voters as dropping
+	resp, err := http.Post(leaderHTTP.URL+"/api/v1/raft/demote", "application/json", bytes.NewBufferString(`{"NodeID": "node2"}`))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.Post(leaderHTTP.URL+"/api/v1/raft/demote", "application/json", bytes.NewBufferString(`{"NodeID": "node2", "Force": true}`))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	success, err = leader.consensus.PromoteRaftHandler(consensus.RequestSuffrage{NodeID: "node2"})
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	resp, body := drop(`{"NodeID": "node9"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body["error"], "not a member")
+
+	resp, body = drop(`{"NodeID": "node2"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body["error"], "below the minimum")
+
+	// The leader hands over to node2, which removes it
+	resp, body = drop(`{"NodeID": "node1", "Force": true}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{
+		"node_id":   "node1",
+		"suffrage":  "voter",
+		"removed":   true,
+		"forced":    true,
+		"voters":    float64(1),
+		"nonvoters": float64(0),
+	}, body["result"])
+	assert.Equal(t, raft.Leader, followerRaft.State())
+	assert.Len(t, followerRaft.GetConfiguration().Configuration().Servers, 1)
+}
+
+func TestSuffrage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/api/v1/raft/demote", `{"NodeID": "node1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the last voter cannot be demoted")
+	assert.Contains(t, w.Body.String(), "last voter")
+
+	w = post("/api/v1/raft/promote", `{"NodeID": "node1"}`)
+	assert.Equal(t, http.StatusOK, w.Code, "promoting a voter changes nothing")
+
+	w = post("/api/v1/raft/promote", `{"NodeID": "node9"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = post("/api/v1/raft/join", `{"NodeID": "node2", "RaftAddress": "127.0.0.1:1", "Suffrage": "observer"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/raft/stat", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, "voter", stats["suffrage"])
+	assert.Equal(t, "node1=voter", stats["members"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/raft/members", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var members struct {
+		Members []consensus.Member `json:"members"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &members))
+	assert.Len(t, members.Members, 1)
+	assert.Equal(t, "node1", members.Members[0].ID)
+	assert.Equal(t, consensus.SuffrageVoter, members.Members[0].Suffrage)
+	assert.True(t, members.Members[0].Leader)
+}
+
+func TestSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+	var secret handler.Secret
+
+	w := do("PUT", "/api/v1/secret/db-creds", `{"password": "one"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":1`)
+	w = do("PUT", "/api/v1/secret/db-creds?cas=1", `{"password": "two"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do("PUT", "/api/v1/secret/db-creds?cas=1", `{"password": "three"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = do("GET", "/api/v1/secret/db-creds", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &secret))
+	assert.Equal(t, uint64(2), secret.Version)
+	assert.Equal(t, map[string]interface{}{"password": "two"}, secret.Value)
+
+	w = do("GET", "/api/v1/secret/db-creds?version=1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"one"`)
+
+	// Soft delete and undelete the current version
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/secret/db-creds", "").Code)
+	w = do("GET", "/api/v1/secret/db-creds", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "deleted")
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/secret/db-creds/undelete", `{"versions": [2]}`).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/secret/db-creds", "").Code)
+
+	// Destroy removes a version for good
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/secret/db-creds/destroy", `{"versions": [1]}`).Code)
+	w = do("GET", "/api/v1/secret/db-creds?version=1", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "destroyed")
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/secret/db-creds/destroy", `{"versions": [9]}`).Code)
+
+	w = do("GET", "/api/v1/secret/db-creds/metadata", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var meta fsm.SecretMetadata
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &meta))
+	assert.Equal(t, uint64(2), meta.CurrentVersion)
+	if assert.Contains(t, meta.Versions, uint64(1)) {
+		assert.True(t, meta.Versions[1].Destroyed)
+	}
+
+	// Secrets are not visible through the KV API
+	w = do("GET", "/api/v1/kv", "")
+	assert.NotContains(t, w.Body.String(), "db-creds")
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/secret/db-creds/metadata", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/secret/db-creds/metadata", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/secret/db-creds", "").Code)
+
+	// Paths may be nested
+	w = do("PUT", "/api/v1/secret/app/db/password", `"hunter2"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"path":"app/db/password"`)
+	w = do("GET", "/api/v1/secret/app/db/password", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"hunter2"`)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/secret/app/db/password", "").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/secret/app/db/password/undelete", `{"versions": [1]}`).Code)
+	w = do("GET", "/api/v1/secret/app/db/password/metadata", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &meta))
+	assert.Equal(t, uint64(1), meta.CurrentVersion)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/secret/app/db", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/secret/app/db/password", `{}`).Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/secret/app/db/password/metadata", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/secret/app/db/password", "").Code)
+}
+
+func TestAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+	s.handler.WithAuth()
+
+	root, created, err := s.handler.InitRootToken()
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("", "GET", "/health", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("", "GET", "/api/v1/kv/app-a", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("bvt.wrong", "GET", "/api/v1/kv/app-a", "").Code)
+
+	policy := `{"rules": [{"path": "kv/app-", "capabilities": ["read", "write", "list"]}]}`
+	assert.Equal(t, http.StatusOK, do(root, "PUT", "/api/v1/auth/policies/app", policy).Code)
+	w := do(root, "GET", "/api/v1/auth/policies", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"app"`)
+
+	w = do(root, "POST", "/api/v1/auth/tokens", `{"name": "app", "policies": ["app"], "ttl": "1h"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created2 map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created2))
+	token, _ := created2["token"].(string)
+	accessor, _ := created2["accessor"].(string)
+	assert.NotEmpty(t, token)
+
+	assert.Equal(t, http.StatusOK, do(token, "PUT", "/api/v1/kv/app-a", `"v"`).Code)
+	assert.Equal(t, http.StatusOK, do(token, "GET", "/api/v1/kv/app-a", "").Code)
+	assert.Equal(t, http.StatusOK, do(token, "GET", "/api/v1/kv?prefix=app-", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "GET", "/api/v1/kv", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "PUT", "/api/v1/kv/other", `"v"`).Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "PUT", "/api/v1/secret/app-db", `"v"`).Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "GET", "/api/v1/raft/stat", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "GET", "/api/v1/auth/tokens", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "POST", "/api/v1/kv/batch", `[{"op": "set", "key": "other", "value": 1}]`).Code)
+	assert.Equal(t, http.StatusForbidden, do(token, "POST", "/api/v1/txn", `{"then": [{"op": "get", "key": "other"}]}`).Code)
+	assert.Equal(t, http.StatusOK, do(token, "POST", "/api/v1/txn", `{"then": [{"op": "set", "key": "app-b", "value": 1}]}`).Code)
+
+	// The token API never returns secrets
+	w = do(root, "GET", "/api/v1/auth/tokens", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), accessor)
+	assert.NotContains(t, w.Body.String(), token)
+	assert.Equal(t, http.StatusOK, do(root, "GET", "/api/v1/auth/tokens/"+accessor, "").Code)
+
+	// Listing values needs read as well as list
+	policy = `{"rules": [{"path": "kv/app-", "capabilities": ["list"]}]}`
+	assert.Equal(t, http.StatusOK, do(root, "PUT", "/api/v1/auth/policies/lister", policy).Code)
+	w = do(root, "POST", "/api/v1/auth/tokens", `{"name": "lister", "policies": ["lister"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var lister map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lister))
+	listToken, _ := lister["token"].(string)
+	assert.Equal(t, http.StatusForbidden, do(listToken, "GET", "/api/v1/kv?prefix=app-", "").Code)
+	w = do(listToken, "GET", "/api/v1/kv?prefix=app-&keys_only=true", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "app-a")
+	assert.NotContains(t, w.Body.String(), `"v"`)
+
+	assert.Equal(t, http.StatusOK, do(root, "DELETE", "/api/v1/auth/tokens/"+accessor, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(root, "GET", "/api/v1/auth/tokens/"+accessor, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(token, "GET", "/api/v1/kv/app-a", "").Code)
+
+	assert.Equal(t, http.StatusBadRequest, do(root, "DELETE", "/api/v1/auth/policies/root", "").Code)
+	assert.Equal(t, http.StatusOK, do(root, "DELETE", "/api/v1/auth/policies/app", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(root, "GET", "/api/v1/auth/policies/app", "").Code)
+}
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ra, db, _, cleanup := newTestRaft(t, "node1", true, nil)
+	defer cleanup()
+	m := metrics.New()
+	defer m.Close()
+	s := newTestServer(ra, db, Options{Metrics: m})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/kv/missing", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `beaver_http_request_duration_seconds_count{method="GET",route="/api/v1/kv/:key",status="404"} 1`)
+
+	// With auth, metrics need a token like the API
+	s.handler.WithAuth()
+	root, _, err := s.handler.InitRootToken()
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+root)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// Pick a free port for Run
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := lis.Addr().String()
+	_ = lis.Close()
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- s.Run(addr) }()
+
+	var resp *http.Response
+	assert.Eventually(t, func() bool {
+		resp, err = http.Get("http://" + addr + "/api/v1/watch?prefix=app-")
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	defer func() { _ = resp.Body.Close() }()
+
+	// Shutdown ends the open watch stream instead of waiting for the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, <-runErr)
+
+	// No new requests are accepted
+	_, err = http.Get("http://" + addr + "/health")
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/subash-0044/beaver-vault/pkg/fsm"
+	"github.com/subash-0044/beaver-vault/pkg/handler"
+)
+
+// watchKeepAlive is how often an idle watch stream sends a comment, so
+// proxies do not time out the connection.
+const watchKeepAlive = 15 * time.Second
+
+// handleWatch streams changes of a key (?key=) or of every key under a
+// prefix (?prefix=) as Server-Sent Events. Each event's id is its Raft
+// index; ?index= or a Last-Event-ID header resumes after that index.
+// Watches are served by the local node, so they work on followers too.
+func (s *Server) handleWatch(c *gin.Context) {
+	var afterIndex uint64
+	raw := c.Query("index")
+	if raw == "" {
+		raw = c.GetHeader("Last-Event-ID")
+	}
+	if raw != "" {
+		var err error
+		if afterIndex, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid index"})
+			return
+		}
+	}
+
+	sub, err := s.handler.Watch(handler.RequestWatch{
+		Key:        c.Query("key"),
+		Prefix:     c.Query("prefix"),
+		AfterIndex: afterIndex,
+	})
+	if err != nil {
+		var compacted *fsm.CompactedError
+		if errors.As(err, &compacted) {
+			c.JSON(http.StatusGone, gin.H{"error": err.Error(), "oldest_index": compacted.Oldest})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					_ = writeEvent(c.Writer, "error", "", gin.H{"error": err.Error()})
+					c.Writer.Flush()
+				}
+				return
+			}
+			id := strconv.FormatUint(event.Index, 10)
+			if err := writeEvent(c.Writer, strings.ToLower(event.Type), id, event); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes one Server-Sent Event with a JSON payload.
+func writeEvent(w io.Writer, name, id string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
@@ -21,6 +21,11 @@ func NewBadgerStore(opts Options) (*BadgerStore, error) {
 	}
 
 	badgerOpts := badger.DefaultOptions(opts.Dir)
+	if opts.EncryptionKey != nil {
+		badgerOpts = badgerOpts.
+			WithEncryptionKey(opts.EncryptionKey).
+			WithIndexCacheSize(encryptedIndexCacheSize)
+	}
 
 	db, err := badger.Open(badgerOpts)
 	if err != nil {
@@ -72,6 +77,25 @@ func (b *BadgerStore) Put(key, data []byte) error {
 	return nil
 }
 
+// PutWithExpiry stores a value for a given key that expires at the given
+// Unix time (in seconds). An expiresAt of zero never expires.
+func (b *BadgerStore) PutWithExpiry(key, data []byte, expiresAt uint64) error {
+	if len(key) == 0 {
+		return ErrKeyCannotBeEmpty
+	}
+
+	err := b.DB.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry(key, data)
+		entry.ExpiresAt = expiresAt
+		return txn.SetEntry(entry)
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to put value: %w", err)
+	}
+	return nil
+}
+
 // Delete removes a key-value pair
 func (b *BadgerStore) Delete(key []byte) error {
 	if len(key) == 0 {
@@ -158,3 +158,24 @@ func TestDelete(t *testing.T) {
 		assert.Contains(t, err.Error(), "key cannot be empty")
 	})
 }
+
+func TestEncryptedBadgerStore(t *testing.T) {
+	tmpDir := t.TempDir()
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	store, err := NewBadgerStore(Options{Dir: tmpDir, CreateIfMissing: true, EncryptionKey: key})
+	require.NoError(t, err)
+	require.NoError(t, store.Put([]byte("k"), []byte("v")))
+	require.NoError(t, store.Close())
+
+	// The files cannot be opened without the key
+	_, err = NewBadgerStore(Options{Dir: tmpDir})
+	assert.Error(t, err)
+
+	store, err = NewBadgerStore(Options{Dir: tmpDir, EncryptionKey: key})
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	value, err := store.Get([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), value)
+}
@@ -0,0 +1,13 @@
+package storage
+
+import "os"
+
+// lockFileExclusive is a no-op on Windows. Badger still refuses to open a
+// directory that another process has open.
+func lockFileExclusive(*os.File) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
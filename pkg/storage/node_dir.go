@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// lockFile is held by the process that owns a node directory
+	lockFile = "LOCK"
+	// identityFile records the ID of the node a directory belongs to
+	identityFile = "node-id"
+)
+
+// NodeDir is the data directory of one node, locked for the lifetime of the
+// process that opened it.
+type NodeDir struct {
+	Path string
+	lock *os.File
+}
+
+// OpenNodeDir creates and locks dir for nodeID. It fails when another
+// process holds the lock, or when dir belongs to a different node. A
+// directory without an identity file is claimed for nodeID.
+func OpenNodeDir(dir, nodeID string) (*NodeDir, error) {
+	if nodeID == "" {
+		return nil, fmt.Errorf("node id is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create node directory: %w", err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFileExclusive(lock); err != nil {
+		_ = lock.Close()
+		return nil, fmt.Errorf("data directory %s is in use by another process: %w", dir, err)
+	}
+	// Record the holder for whoever finds the directory locked
+	if err := lock.Truncate(0); err == nil {
+		_, _ = lock.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+
+	d := &NodeDir{Path: dir, lock: lock}
+	if err := d.checkIdentity(nodeID); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// checkIdentity compares nodeID with the identity file, writing it first
+// if it is missing.
+func (d *NodeDir) checkIdentity(nodeID string) error {
+	path := filepath.Join(d.Path, identityFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(path, []byte(nodeID+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write node identity: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read node identity: %w", err)
+	}
+	if existing := strings.TrimSpace(string(data)); existing != nodeID {
+		return fmt.Errorf("data directory %s belongs to node %q, not %q", d.Path, existing, nodeID)
+	}
+	return nil
+}
+
+// Close releases the lock.
+func (d *NodeDir) Close() error {
+	if d.lock == nil {
+		return nil
+	}
+	err := unlockFile(d.lock)
+	if closeErr := d.lock.Close(); err == nil {
+		err = closeErr
+	}
+	d.lock = nil
+	return err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenNodeDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "node1")
+
+	t.Run("should claim a new directory", func(t *testing.T) {
+		d, err := OpenNodeDir(dir, "node1")
+		require.NoError(t, err)
+		data, err := os.ReadFile(filepath.Join(dir, identityFile))
+		assert.NoError(t, err)
+		assert.Equal(t, "node1\n", string(data))
+		assert.NoError(t, d.Close())
+	})
+
+	t.Run("should refuse a second open while locked", func(t *testing.T) {
+		d, err := OpenNodeDir(dir, "node1")
+		require.NoError(t, err)
+		defer func() { _ = d.Close() }()
+
+		_, err = OpenNodeDir(dir, "node1")
+		assert.ErrorContains(t, err, "in use")
+	})
+
+	t.Run("should reopen after close", func(t *testing.T) {
+		d, err := OpenNodeDir(dir, "node1")
+		require.NoError(t, err)
+		assert.NoError(t, d.Close())
+		assert.NoError(t, d.Close(), "closing twice is harmless")
+	})
+
+	t.Run("should refuse a different node id", func(t *testing.T) {
+		_, err := OpenNodeDir(dir, "node2")
+		assert.ErrorContains(t, err, `belongs to node "node1"`)
+
+		// The failed open released the lock
+		d, err := OpenNodeDir(dir, "node1")
+		require.NoError(t, err)
+		assert.NoError(t, d.Close())
+	})
+}
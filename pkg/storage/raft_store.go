@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// prefixLog namespaces Raft log entries, keyed by big-endian index so
+	// that Badger's key order matches log order.
+	prefixLog = []byte("log/")
+	// prefixStable namespaces StableStore entries such as the current term
+	// and the last vote.
+	prefixStable = []byte("stable/")
+)
+
+// RaftStore implements raft.LogStore and raft.StableStore on top of a
+// dedicated BadgerDB instance, so that a node keeps its term, vote and log
+// across restarts.
+type RaftStore struct {
+	DB *badger.DB
+}
+
+// NewRaftStore opens (or creates) a Raft log and stable store in dir.
+func NewRaftStore(dir string) (*RaftStore, error) {
+	return NewEncryptedRaftStore(dir, nil)
+}
+
+// NewEncryptedRaftStore opens a store like NewRaftStore whose files Badger
+// encrypts with encryptionKey. A nil key disables encryption.
+func NewEncryptedRaftStore(dir string, encryptionKey []byte) (*RaftStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Raft assumes that anything it stored is on disk before it answers
+	// a vote or acknowledges an append, so every write must be synced.
+	badgerOpts := badger.DefaultOptions(dir).WithSyncWrites(true)
+	if encryptionKey != nil {
+		badgerOpts = badgerOpts.
+			WithEncryptionKey(encryptionKey).
+			WithIndexCacheSize(encryptedIndexCacheSize)
+	}
+
+	db, err := badger.Open(badgerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft store: %w", err)
+	}
+
+	return &RaftStore{DB: db}, nil
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (s *RaftStore) FirstIndex() (uint64, error) {
+	return s.edgeIndex(false)
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (s *RaftStore) LastIndex() (uint64, error) {
+	return s.edgeIndex(true)
+}
+
+// edgeIndex returns the lowest or highest stored log index.
+func (s *RaftStore) edgeIndex(reverse bool) (uint64, error) {
+	var index uint64
+	err := s.DB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = reverse
+		opts.Prefix = prefixLog
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		seek := prefixLog
+		if reverse {
+			seek = append(append([]byte{}, prefixLog...), 0xff)
+		}
+		it.Seek(seek)
+		if it.ValidForPrefix(prefixLog) {
+			index = decodeLogKey(it.Item().Key())
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read log index: %w", err)
+	}
+	return index, nil
+}
+
+// GetLog gets a log entry at a given index.
+func (s *RaftStore) GetLog(index uint64, log *raft.Log) error {
+	err := s.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(logKey(index))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, log)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get log %d: %w", index, err)
+	}
+	return nil
+}
+
+// StoreLog stores a log entry.
+func (s *RaftStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries, in as few transactions as Badger's
+// transaction size limit allows. Entries are committed in order, so a
+// failure leaves a prefix of them stored, which Raft tolerates.
+func (s *RaftStore) StoreLogs(logs []*raft.Log) error {
+	if err := s.storeLogs(logs); err != nil {
+		return fmt.Errorf("failed to store logs: %w", err)
+	}
+	return nil
+}
+
+func (s *RaftStore) storeLogs(logs []*raft.Log) error {
+	txn := s.DB.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	for _, log := range logs {
+		data, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		key := logKey(log.Index)
+		err = txn.Set(key, data)
+		if err == badger.ErrTxnTooBig {
+			// Commit what fits and carry on in a new transaction
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = s.DB.NewTransaction(true)
+			err = txn.Set(key, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive.
+func (s *RaftStore) DeleteRange(minIdx, maxIdx uint64) error {
+	wb := s.DB.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := s.DB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefixLog
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(logKey(minIdx)); it.ValidForPrefix(prefixLog); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if decodeLogKey(key) > maxIdx {
+				break
+			}
+			if err := wb.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete logs: %w", err)
+	}
+
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("failed to delete logs: %w", err)
+	}
+	return nil
+}
+
+// Set stores a stable value for a given key.
+func (s *RaftStore) Set(key, val []byte) error {
+	err := s.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(stableKey(key), val)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set stable key: %w", err)
+	}
+	return nil
+}
+
+// Get returns the stable value for key, or ErrNotFound if it was never set.
+func (s *RaftStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(stableKey(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stable key: %w", err)
+	}
+	return value, nil
+}
+
+// SetUint64 stores a uint64 stable value for a given key.
+func (s *RaftStore) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return s.Set(key, buf)
+}
+
+// GetUint64 returns the uint64 stable value for key, or ErrNotFound if it
+// was never set.
+func (s *RaftStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid uint64 value for key %s", key)
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// Close closes the database connection
+func (s *RaftStore) Close() error {
+	return s.DB.Close()
+}
+
+func logKey(index uint64) []byte {
+	key := make([]byte, len(prefixLog)+8)
+	copy(key, prefixLog)
+	binary.BigEndian.PutUint64(key[len(prefixLog):], index)
+	return key
+}
+
+func decodeLogKey(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(prefixLog):])
+}
+
+func stableKey(key []byte) []byte {
+	return append(append([]byte{}, prefixStable...), key...)
+}
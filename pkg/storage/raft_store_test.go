@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaftStoreLogs(t *testing.T) {
+	store, err := NewRaftStore(t.TempDir())
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	t.Run("should report zero indexes when empty", func(t *testing.T) {
+		first, err := store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), first)
+
+		last, err := store.LastIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), last)
+	})
+
+	t.Run("should store and read back logs", func(t *testing.T) {
+		logs := []*raft.Log{
+			{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("one")},
+			{Index: 2, Term: 1, Type: raft.LogCommand, Data: []byte("two")},
+			{Index: 300, Term: 2, Type: raft.LogCommand, Data: []byte("three hundred")},
+		}
+		require.NoError(t, store.StoreLogs(logs))
+
+		first, err := store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), first)
+
+		last, err := store.LastIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(300), last)
+
+		var log raft.Log
+		require.NoError(t, store.GetLog(300, &log))
+		assert.Equal(t, uint64(2), log.Term)
+		assert.Equal(t, []byte("three hundred"), log.Data)
+	})
+
+	t.Run("should store an append larger than a transaction", func(t *testing.T) {
+		// A full append of large batch writes. Entries under Badger's value
+		// threshold are kept in the LSM tree and count fully towards the
+		// transaction size.
+		data := bytes.Repeat([]byte("x"), 512<<10)
+		logs := make([]*raft.Log, 64)
+		for i := range logs {
+			logs[i] = &raft.Log{Index: uint64(1001 + i), Term: 2, Type: raft.LogCommand, Data: data}
+		}
+		require.NoError(t, store.StoreLogs(logs))
+
+		for _, want := range logs {
+			var log raft.Log
+			require.NoError(t, store.GetLog(want.Index, &log))
+			assert.Len(t, log.Data, len(data))
+		}
+		require.NoError(t, store.DeleteRange(1001, 1064))
+	})
+
+	t.Run("should return ErrLogNotFound for missing index", func(t *testing.T) {
+		var log raft.Log
+		assert.Equal(t, raft.ErrLogNotFound, store.GetLog(42, &log))
+	})
+
+	t.Run("should delete an inclusive range", func(t *testing.T) {
+		require.NoError(t, store.DeleteRange(1, 2))
+
+		first, err := store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(300), first)
+
+		var log raft.Log
+		assert.Equal(t, raft.ErrLogNotFound, store.GetLog(2, &log))
+	})
+}
+
+func TestRaftStoreStable(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewRaftStore(dir)
+	require.NoError(t, err)
+
+	t.Run("should return not found for unset keys", func(t *testing.T) {
+		_, err := store.Get([]byte("missing"))
+		assert.EqualError(t, err, "not found")
+
+		_, err = store.GetUint64([]byte("missing"))
+		assert.EqualError(t, err, "not found")
+	})
+
+	t.Run("should persist values across reopen", func(t *testing.T) {
+		require.NoError(t, store.Set([]byte("LastVoteCand"), []byte("node1")))
+		require.NoError(t, store.SetUint64([]byte("CurrentTerm"), 7))
+		require.NoError(t, store.Close())
+
+		store, err = NewRaftStore(dir)
+		require.NoError(t, err)
+
+		value, err := store.Get([]byte("LastVoteCand"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("node1"), value)
+
+		term, err := store.GetUint64([]byte("CurrentTerm"))
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(7), term)
+	})
+
+	assert.NoError(t, store.Close())
+}
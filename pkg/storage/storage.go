@@ -6,6 +6,9 @@ import "errors"
 var (
 	ErrKeyNotFound      = errors.New("key not found")
 	ErrKeyCannotBeEmpty = errors.New("key cannot be empty")
+	// ErrNotFound is returned by RaftStore for unset stable keys. Raft
+	// compares the error text, so the message must stay "not found".
+	ErrNotFound = errors.New("not found")
 )
 
 type Value struct {
@@ -25,4 +28,11 @@ type Options struct {
 	Dir string
 	// Whether to create the directory if it doesn't exist
 	CreateIfMissing bool
+	// EncryptionKey, if set, makes Badger encrypt its files with this
+	// 16, 24 or 32 byte AES key
+	EncryptionKey []byte
 }
+
+// encryptedIndexCacheSize is the block index cache Badger requires when
+// encryption is enabled.
+const encryptedIndexCacheSize = 100 << 20
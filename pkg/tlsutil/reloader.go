@@ -0,0 +1,213 @@
+// Package tlsutil loads the TLS identity of a node and keeps it up to date
+// when the certificate files change.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultReloadInterval is how often the certificate files are checked for
+// changes when no interval is given.
+const DefaultReloadInterval = 10 * time.Second
+
+// Files locates the PEM files of a node's TLS identity.
+type Files struct {
+	CertFile string
+	KeyFile  string
+	// CAFile holds the certificates that sign peer and client certificates.
+	// It is required for mutual TLS.
+	CAFile string
+}
+
+// Reloader serves the current certificate and CA pool of a node to TLS
+// configs, so new connections pick up reloaded files without a restart.
+// Established connections keep the certificate they were opened with.
+type Reloader struct {
+	files Files
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewReloader loads files and returns a Reloader serving them.
+func NewReloader(files Files) (*Reloader, error) {
+	if files.CertFile == "" || files.KeyFile == "" {
+		return nil, fmt.Errorf("tls certificate and key files are required")
+	}
+	r := &Reloader{files: files}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. On error the previous certificate and CA
+// pool stay in use.
+func (r *Reloader) Reload() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+	// Files that fail to load are not retried until they change again
+	r.mu.Lock()
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %v", err)
+	}
+	var pool *x509.CertPool
+	if r.files.CAFile != "" {
+		pem, err := os.ReadFile(r.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("error reading tls ca file: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.files.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the files when this process receives SIGHUP or when one of
+// them changes, checking every interval. Close stops it.
+func (r *Reloader) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	r.stopCh = make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer signal.Stop(hup)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+				r.reloadAndLog("SIGHUP")
+			case <-ticker.C:
+				if r.changed() {
+					r.reloadAndLog("file change")
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops Watch.
+func (r *Reloader) Close() {
+	if r.stopCh == nil {
+		return
+	}
+	close(r.stopCh)
+	r.wg.Wait()
+	r.stopCh = nil
+}
+
+// Mutual reports whether a CA is configured to verify peer certificates.
+func (r *Reloader) Mutual() bool {
+	return r.files.CAFile != ""
+}
+
+// ServerConfig returns a TLS config for listeners. With clientAuth, clients
+// must present a certificate signed by the CA.
+func (r *Reloader) ServerConfig(clientAuth bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if clientAuth {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = r.pool
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig returns a TLS config for connections to serverName, which
+// present this node's certificate and verify the server against the CA.
+// Without a CA the system roots are used.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		ServerName:   serverName,
+		RootCAs:      r.pool,
+		Certificates: []tls.Certificate{*r.cert},
+	}
+}
+
+func (r *Reloader) reloadAndLog(reason string) {
+	if err := r.Reload(); err != nil {
+		log.Printf("Error reloading TLS certificates after %s: %v", reason, err)
+		return
+	}
+	log.Printf("Reloaded TLS certificates after %s", reason)
+}
+
+// changed reports whether a file was modified since it was last loaded.
+func (r *Reloader) changed() bool {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) statFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, name := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tls file: %v", err)
+		}
+		modTimes[name] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,106 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificates writes a CA and a certificate for 127.0.0.1 signed by
+// it to dir, and returns their files.
+func writeCertificates(t *testing.T, dir, commonName string) Files {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName + " CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := Files{
+		CertFile: filepath.Join(dir, "node.crt"),
+		KeyFile:  filepath.Join(dir, "node.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	write := func(name, blockType string, der []byte) {
+		require.NoError(t, os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	}
+	write(files.CAFile, "CERTIFICATE", caDER)
+	write(files.CertFile, "CERTIFICATE", der)
+	write(files.KeyFile, "EC PRIVATE KEY", keyDER)
+	return files
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	files := writeCertificates(t, dir, "first")
+
+	_, err := NewReloader(Files{CertFile: files.CertFile})
+	assert.Error(t, err, "key file is required")
+
+	r, err := NewReloader(files)
+	require.NoError(t, err)
+	assert.True(t, r.Mutual())
+
+	commonName := func() string {
+		config, err := r.ServerConfig(true).GetConfigForClient(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+		leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, "first", commonName())
+
+	// A failed reload keeps the current certificate
+	require.NoError(t, os.WriteFile(files.KeyFile, []byte("garbage"), 0600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, "first", commonName())
+
+	// Changed files are picked up by Watch
+	r.Watch(20 * time.Millisecond)
+	defer r.Close()
+	time.Sleep(50 * time.Millisecond)
+	writeCertificates(t, dir, "second")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && commonName() != "second" {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, "second", commonName())
+	assert.Equal(t, "127.0.0.1", r.ClientConfig("127.0.0.1").ServerName)
+}